@@ -0,0 +1,222 @@
+package tweetenc
+
+import (
+	"errors"
+	"math"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/anyvec/anyvecsave"
+	"github.com/unixpickle/serializer"
+)
+
+func init() {
+	var a AdamState
+	serializer.RegisterTypedDeserializer(a.SerializerType(), DeserializeAdamState)
+}
+
+// Adam is an anysgd.Transformer which implements the
+// adaptive moments technique described in
+// https://arxiv.org/abs/1412.6980.
+//
+// It is equivalent to anysgd.Adam, except that its state
+// can be exported and restored, allowing training to be
+// resumed without resetting the moment estimates.
+//
+// Fields which are zero are replaced with defaults.
+type Adam struct {
+	DecayRate1 float64
+	DecayRate2 float64
+	Damping    float64
+
+	// Iteration is the number of steps taken so far.
+	Iteration int
+
+	FirstMoment  anydiff.Grad
+	SecondMoment anydiff.Grad
+}
+
+// Transform transforms the gradient in place and returns
+// it.
+func (a *Adam) Transform(g anydiff.Grad) anydiff.Grad {
+	if a.FirstMoment == nil {
+		a.FirstMoment = anydiff.Grad{}
+		a.SecondMoment = anydiff.Grad{}
+	}
+	a.Iteration++
+	rate1, rate2, damping := a.hyperParams()
+	correction1 := 1 / (1 - math.Pow(rate1, float64(a.Iteration)))
+	correction2 := 1 / (1 - math.Pow(rate2, float64(a.Iteration)))
+
+	for p, grad := range g {
+		c := grad.Creator()
+		first, ok := a.FirstMoment[p]
+		if !ok {
+			first = c.MakeVector(grad.Len())
+			a.FirstMoment[p] = first
+			a.SecondMoment[p] = c.MakeVector(grad.Len())
+		}
+		second := a.SecondMoment[p]
+
+		first.Scale(c.MakeNumeric(rate1))
+		scaledGrad := grad.Copy()
+		scaledGrad.Scale(c.MakeNumeric(1 - rate1))
+		first.Add(scaledGrad)
+
+		second.Scale(c.MakeNumeric(rate2))
+		sqGrad := grad.Copy()
+		sqGrad.Mul(grad)
+		sqGrad.Scale(c.MakeNumeric(1 - rate2))
+		second.Add(sqGrad)
+
+		denom := second.Copy()
+		denom.Scale(c.MakeNumeric(correction2))
+		anyvec.Pow(denom, c.MakeNumeric(0.5))
+		denom.AddScalar(c.MakeNumeric(damping))
+
+		grad.Set(first)
+		grad.Scale(c.MakeNumeric(correction1))
+		grad.Div(denom)
+	}
+	return g
+}
+
+// State exports the optimizer's state.
+//
+// The moment estimates are stored in the same order as
+// params.
+// Parameters which have not been updated yet get zero
+// moment estimates.
+func (a *Adam) State(params []*anydiff.Var) *AdamState {
+	res := &AdamState{Iteration: a.Iteration}
+	res.DecayRate1, res.DecayRate2, res.Damping = a.hyperParams()
+	for _, p := range params {
+		first, ok := a.FirstMoment[p]
+		second := a.SecondMoment[p]
+		if !ok {
+			first = p.Vector.Creator().MakeVector(p.Vector.Len())
+			second = p.Vector.Creator().MakeVector(p.Vector.Len())
+		}
+		res.FirstMoment = append(res.FirstMoment, first)
+		res.SecondMoment = append(res.SecondMoment, second)
+	}
+	return res
+}
+
+// SetState restores the optimizer's state from the result
+// of State.
+//
+// The parameters must correspond to the ones that were
+// passed to State.
+func (a *Adam) SetState(params []*anydiff.Var, s *AdamState) error {
+	if len(s.FirstMoment) != len(params) || len(s.SecondMoment) != len(params) {
+		return errors.New("set Adam state: parameter count mismatch")
+	}
+	for i, p := range params {
+		if s.FirstMoment[i].Len() != p.Vector.Len() ||
+			s.SecondMoment[i].Len() != p.Vector.Len() {
+			return errors.New("set Adam state: parameter size mismatch")
+		}
+	}
+	a.DecayRate1 = s.DecayRate1
+	a.DecayRate2 = s.DecayRate2
+	a.Damping = s.Damping
+	a.Iteration = s.Iteration
+	a.FirstMoment = anydiff.Grad{}
+	a.SecondMoment = anydiff.Grad{}
+	for i, p := range params {
+		a.FirstMoment[p] = s.FirstMoment[i]
+		a.SecondMoment[p] = s.SecondMoment[i]
+	}
+	return nil
+}
+
+func (a *Adam) hyperParams() (rate1, rate2, damping float64) {
+	rate1, rate2, damping = a.DecayRate1, a.DecayRate2, a.Damping
+	if rate1 == 0 {
+		rate1 = 0.9
+	}
+	if rate2 == 0 {
+		rate2 = 0.999
+	}
+	if damping == 0 {
+		damping = 1e-8
+	}
+	return
+}
+
+// AdamState is the serializable state of an Adam
+// optimizer, as produced by Adam.State.
+type AdamState struct {
+	DecayRate1 float64
+	DecayRate2 float64
+	Damping    float64
+	Iteration  int
+
+	FirstMoment  []anyvec.Vector
+	SecondMoment []anyvec.Vector
+}
+
+// DeserializeAdamState deserializes an AdamState.
+func DeserializeAdamState(d []byte) (*AdamState, error) {
+	var res AdamState
+	var firstData, secondData []byte
+	err := serializer.DeserializeAny(d, &res.DecayRate1, &res.DecayRate2, &res.Damping,
+		&res.Iteration, &firstData, &secondData)
+	if err != nil {
+		return nil, errors.New("deserialize AdamState: " + err.Error())
+	}
+	res.FirstMoment, err = deserializeVectors(firstData)
+	if err != nil {
+		return nil, errors.New("deserialize AdamState: " + err.Error())
+	}
+	res.SecondMoment, err = deserializeVectors(secondData)
+	if err != nil {
+		return nil, errors.New("deserialize AdamState: " + err.Error())
+	}
+	return &res, nil
+}
+
+// SerializerType returns the unique ID used to serialize
+// an AdamState with the serializer package.
+func (a *AdamState) SerializerType() string {
+	return "github.com/unixpickle/tweetenc.AdamState"
+}
+
+// Serialize serializes the AdamState.
+func (a *AdamState) Serialize() ([]byte, error) {
+	firstData, err := serializeVectors(a.FirstMoment)
+	if err != nil {
+		return nil, err
+	}
+	secondData, err := serializeVectors(a.SecondMoment)
+	if err != nil {
+		return nil, err
+	}
+	return serializer.SerializeAny(a.DecayRate1, a.DecayRate2, a.Damping, a.Iteration,
+		firstData, secondData)
+}
+
+func serializeVectors(vecs []anyvec.Vector) ([]byte, error) {
+	var list []serializer.Serializer
+	for _, v := range vecs {
+		list = append(list, &anyvecsave.S{Vector: v})
+	}
+	return serializer.SerializeSlice(list)
+}
+
+func deserializeVectors(d []byte) ([]anyvec.Vector, error) {
+	list, err := serializer.DeserializeSlice(d)
+	if err != nil {
+		return nil, err
+	}
+	var res []anyvec.Vector
+	for _, obj := range list {
+		vec, ok := obj.(*anyvecsave.S)
+		if !ok {
+			return nil, errors.New("expected vector")
+		}
+		res = append(res, vec.Vector)
+	}
+	return res, nil
+}
@@ -10,33 +10,38 @@ import (
 	"os"
 	"time"
 
-	"github.com/unixpickle/anynet/anysgd"
 	"github.com/unixpickle/anyvec"
-	"github.com/unixpickle/serializer"
 	"github.com/unixpickle/tweetenc"
 )
 
 func main() {
-	rand.Seed(time.Now().UnixNano())
-
 	var dataPath string
+	var modelPath string
 	var encPath string
 	var numSamples int
 	var batchSize int
+	var seed int64
 	flag.StringVar(&dataPath, "data", "", "tweet data")
-	flag.StringVar(&encPath, "encoder", "../train/enc_out", "encoder network")
+	flag.StringVar(&modelPath, "model", "../train/model_out", "model file")
+	flag.StringVar(&encPath, "encoder", "../train/enc_out", "legacy encoder network")
 	flag.IntVar(&numSamples, "num", 512, "number of samples")
 	flag.IntVar(&batchSize, "batch", 32, "batch size")
+	flag.Int64Var(&seed, "seed", 0, "random seed (0 to seed from the time)")
 	flag.Parse()
 
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	gen := rand.New(tweetenc.NewRandSource(seed))
+
 	if dataPath == "" {
 		fmt.Fprintln(os.Stderr, "Missing -data flag. See -help for more.")
 		os.Exit(1)
 	}
 
 	log.Println("Loading encoder...")
-	var encoder *tweetenc.Encoder
-	if err := serializer.LoadAny(encPath, &encoder); err != nil {
+	encoder, err := tweetenc.LoadEncoderFiles(modelPath, encPath)
+	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to load encoder:", err)
 		os.Exit(1)
 	}
@@ -47,7 +52,7 @@ func main() {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	anysgd.Shuffle(samples)
+	gen.Shuffle(len(samples), samples.Swap)
 
 	log.Println("Computing statistics...")
 
@@ -0,0 +1,279 @@
+package tweetenc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyrnn"
+	"github.com/unixpickle/anyvec"
+)
+
+// defaultInputScale is the default factor by which the
+// input weights of LSTMs are scaled.
+const defaultInputScale = 16
+
+// An Architecture describes the structure of an
+// encoder/decoder pair.
+//
+// Architectures are usually loaded from JSON files.
+type Architecture struct {
+	LatentSize int `json:"latent_size"`
+
+	// InputScale is the factor by which the input weights
+	// of LSTM layers are scaled.
+	// If 0, a default is used.
+	//
+	// GRU layers do not support input scaling, so it must
+	// be 0 if any encoder layer is a GRU.
+	InputScale float64 `json:"input_scale,omitempty"`
+
+	// EncoderLayers and DecoderLayers are the recurrent
+	// layers of the encoder and decoder, from input to
+	// output.
+	//
+	// Decoder layers must be LSTMs, since their states
+	// are initialized from the encoded vectors.
+	EncoderLayers []*LayerSpec `json:"encoder_layers"`
+	DecoderLayers []*LayerSpec `json:"decoder_layers"`
+
+	// Bidirectional, if true, gives the encoder a second
+	// stack of EncoderLayers which reads strings forwards.
+	Bidirectional bool `json:"bidirectional,omitempty"`
+
+	// Pooling determines how the encoder summarizes its
+	// outputs: "tail" (the final outputs), "mean", "max",
+	// or "attention".
+	// If empty, "tail" is used.
+	Pooling string `json:"pooling,omitempty"`
+
+	// AttentionHead maps each encoder output to an
+	// attention score when Pooling is "attention".
+	AttentionHead HeadSpec `json:"attention_head"`
+
+	MeanHead    HeadSpec `json:"mean_head"`
+	StddevHead  HeadSpec `json:"stddev_head"`
+	StateMapper HeadSpec `json:"state_mapper"`
+}
+
+// A LayerSpec describes a recurrent layer.
+type LayerSpec struct {
+	// Cell is "lstm" or "gru".
+	Cell string `json:"cell"`
+
+	StateSize int `json:"state_size"`
+}
+
+// A HeadSpec describes a feed-forward network.
+type HeadSpec struct {
+	// Hidden contains the sizes of the hidden layers.
+	// If it is empty, the network is a single linear
+	// layer.
+	Hidden []int `json:"hidden,omitempty"`
+
+	// Activation is the activation applied after each
+	// hidden layer: "tanh", "relu", or "sigmoid".
+	// If empty, "tanh" is used.
+	Activation string `json:"activation,omitempty"`
+}
+
+// DefaultArchitecture creates the Architecture used by
+// NewEncoder and NewDecoder.
+func DefaultArchitecture(encodedSize, stateSize int) *Architecture {
+	var layers []*LayerSpec
+	for i := 0; i < 3; i++ {
+		layers = append(layers, &LayerSpec{Cell: "lstm", StateSize: stateSize})
+	}
+	return &Architecture{
+		LatentSize:    encodedSize,
+		EncoderLayers: layers,
+		DecoderLayers: layers,
+	}
+}
+
+// ReadArchitecture reads a JSON Architecture file.
+func ReadArchitecture(path string) (*Architecture, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("read architecture: " + err.Error())
+	}
+	var res Architecture
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, errors.New("read architecture: " + err.Error())
+	}
+	if err := res.Validate(); err != nil {
+		return nil, errors.New("read architecture: " + err.Error())
+	}
+	return &res, nil
+}
+
+// Validate checks that the Architecture is well-formed.
+func (a *Architecture) Validate() error {
+	if a.LatentSize <= 0 {
+		return errors.New("latent size must be positive")
+	}
+	if len(a.EncoderLayers) == 0 || len(a.DecoderLayers) == 0 {
+		return errors.New("encoder and decoder need at least one layer")
+	}
+	for _, layer := range a.EncoderLayers {
+		if layer.Cell != "lstm" && layer.Cell != "gru" {
+			return fmt.Errorf("unknown cell type: %s", layer.Cell)
+		}
+		if layer.StateSize <= 0 {
+			return errors.New("state sizes must be positive")
+		}
+		if layer.Cell == "gru" && a.InputScale != 0 {
+			return errors.New("input scale is not supported for GRU layers")
+		}
+	}
+	for _, layer := range a.DecoderLayers {
+		if layer.Cell != "lstm" {
+			return errors.New("decoder layers must be LSTMs")
+		}
+		if layer.StateSize <= 0 {
+			return errors.New("state sizes must be positive")
+		}
+	}
+	switch a.Pooling {
+	case "", "tail", "mean", "max", "attention":
+	default:
+		return fmt.Errorf("unknown pooling: %s", a.Pooling)
+	}
+	heads := []HeadSpec{a.MeanHead, a.StddevHead, a.StateMapper, a.AttentionHead}
+	for _, head := range heads {
+		if _, err := head.activation(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StateSize returns the state size shared by every
+// recurrent layer, or 0 if the layers have different
+// state sizes.
+func (a *Architecture) StateSize() int {
+	var res int
+	for _, layer := range append(append([]*LayerSpec{}, a.EncoderLayers...),
+		a.DecoderLayers...) {
+		if res != 0 && layer.StateSize != res {
+			return 0
+		}
+		res = layer.StateSize
+	}
+	return res
+}
+
+// NewEncoderArch creates an Encoder with the given
+// Architecture and Vocabulary.
+//
+// If v is nil, the Encoder uses a ByteVocabulary.
+func NewEncoderArch(c anyvec.Creator, a *Architecture, v Vocabulary) *Encoder {
+	outSize := a.EncoderLayers[len(a.EncoderLayers)-1].StateSize
+	res := &Encoder{Vocab: v}
+	inSize := res.vocab().Size()
+	res.Block = a.recurrentStack(c, inSize, a.EncoderLayers)
+	if a.Bidirectional {
+		res.Forward = a.recurrentStack(c, inSize, a.EncoderLayers)
+		outSize *= 2
+	}
+	res.Pool = a.pooler(c)
+	stddev := a.StddevHead.build(c, outSize, a.LatentSize)
+	lastLayer := stddev[len(stddev)-1].(*anynet.FC)
+	lastLayer.Biases.Vector.AddScalar(c.MakeNumeric(initStddevBias))
+	res.MeanEncoder = a.MeanHead.build(c, outSize, a.LatentSize)
+	res.StddevEncoder = stddev
+	return res
+}
+
+// NewDecoderArch creates a Decoder with the given
+// Architecture and Vocabulary.
+//
+// If v is nil, the Decoder uses a ByteVocabulary.
+func NewDecoderArch(c anyvec.Creator, a *Architecture, v Vocabulary) *Decoder {
+	var stateSize int
+	for _, layer := range a.DecoderLayers {
+		stateSize += layer.StateSize * 2
+	}
+	res := &Decoder{Vocab: v}
+	vocabSize := res.vocab().Size()
+	outSize := a.DecoderLayers[len(a.DecoderLayers)-1].StateSize
+	res.Block = a.recurrentStack(c, vocabSize, a.DecoderLayers)
+	res.Block = append(res.Block, &anyrnn.LayerBlock{
+		Layer: anynet.Net{
+			anynet.NewFC(c, outSize, vocabSize),
+			anynet.LogSoftmax,
+		},
+	})
+	res.StateMapper = a.StateMapper.build(c, a.LatentSize, stateSize)
+	return res
+}
+
+func (a *Architecture) pooler(c anyvec.Creator) Pooler {
+	switch a.Pooling {
+	case "", "tail":
+		return nil
+	case "mean":
+		return MeanPool{}
+	case "max":
+		return MaxPool{}
+	case "attention":
+		inSize := a.EncoderLayers[len(a.EncoderLayers)-1].StateSize
+		return &AttentionPool{Scorer: a.AttentionHead.build(c, inSize, 1)}
+	default:
+		panic("unknown pooling: " + a.Pooling)
+	}
+}
+
+func (a *Architecture) recurrentStack(c anyvec.Creator, inSize int,
+	layers []*LayerSpec) anyrnn.Stack {
+	scale := a.InputScale
+	if scale == 0 {
+		scale = defaultInputScale
+	}
+	scaler := c.MakeNumeric(scale)
+
+	var res anyrnn.Stack
+	for _, layer := range layers {
+		switch layer.Cell {
+		case "lstm":
+			lstm := anyrnn.NewLSTM(c, inSize, layer.StateSize).ScaleInWeights(scaler)
+			res = append(res, lstm)
+		case "gru":
+			// GRUs have no equivalent of ScaleInWeights, which
+			// is why Validate rejects InputScale for them.
+			res = append(res, anyrnn.NewGRU(c, inSize, layer.StateSize))
+		default:
+			panic("unknown cell type: " + layer.Cell)
+		}
+		inSize = layer.StateSize
+	}
+	return res
+}
+
+func (h *HeadSpec) build(c anyvec.Creator, inSize, outSize int) anynet.Net {
+	activation, err := h.activation()
+	if err != nil {
+		panic(err)
+	}
+	var res anynet.Net
+	for _, hidden := range h.Hidden {
+		res = append(res, anynet.NewFC(c, inSize, hidden), activation)
+		inSize = hidden
+	}
+	return append(res, anynet.NewFC(c, inSize, outSize))
+}
+
+func (h *HeadSpec) activation() (anynet.Layer, error) {
+	switch h.Activation {
+	case "", "tanh":
+		return anynet.Tanh, nil
+	case "relu":
+		return anynet.ReLU, nil
+	case "sigmoid":
+		return anynet.Sigmoid, nil
+	default:
+		return nil, fmt.Errorf("unknown activation: %s", h.Activation)
+	}
+}
@@ -0,0 +1,183 @@
+package tweetenc
+
+import (
+	"sort"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet/anyrnn"
+	"github.com/unixpickle/anyvec"
+)
+
+// A BeamCandidate is one of the results of a beam search.
+type BeamCandidate struct {
+	// Bytes is the decoded string, excluding the
+	// null-terminator.
+	Bytes []byte
+
+	// Tokens contains the decoded tokens, excluding the
+	// null-terminator.
+	Tokens []int
+
+	// LogProb is the cumulative log-probability of the
+	// string, including the null-terminator if the string
+	// was terminated.
+	LogProb float64
+
+	// Truncated is true if the string was cut off at the
+	// maximum length rather than being null-terminated.
+	Truncated bool
+}
+
+// Beam decodes a feature vector with beam search.
+//
+// At every timestep, the beamSize most likely partial
+// strings are kept and expanded.
+// Strings which reach maxLen tokens without producing a
+// null-terminator are cut off.
+//
+// The result contains up to beamSize candidates, sorted
+// from most to least likely.
+func (d *Decoder) Beam(encoded anyvec.Vector, beamSize, maxLen int) []*BeamCandidate {
+	if beamSize < 1 {
+		panic("beam size must be at least 1")
+	}
+	c := encoded.Creator()
+	vocab := d.vocab()
+	size := vocab.Size()
+	mapped := d.StateMapper.Apply(anydiff.NewConst(encoded), 1)
+	state := d.vecToState(mapped.Output(), 1)
+
+	beam := []*BeamCandidate{{}}
+	var done []*BeamCandidate
+	for len(beam) > 0 {
+		if len(beam[0].Tokens) >= maxLen {
+			for _, cand := range beam {
+				cand.Truncated = true
+				done = append(done, cand)
+			}
+			break
+		}
+
+		var inputs []anyvec.Vector
+		for _, cand := range beam {
+			var last int
+			if len(cand.Tokens) > 0 {
+				last = cand.Tokens[len(cand.Tokens)-1]
+			}
+			inputs = append(inputs, oneHot(c, size, last))
+		}
+		next := d.Block.Step(state, c.Concat(inputs...))
+		logProbs := vectorData(next.Output())
+
+		var expansions []beamExpansion
+		for i, cand := range beam {
+			for token := 0; token < size; token++ {
+				expansions = append(expansions, beamExpansion{
+					Parent:  i,
+					Token:   token,
+					LogProb: cand.LogProb + logProbs[i*size+token],
+				})
+			}
+		}
+		sort.Slice(expansions, func(i, j int) bool {
+			return expansions[i].LogProb > expansions[j].LogProb
+		})
+
+		if len(expansions) > beamSize {
+			expansions = expansions[:beamSize]
+		}
+		var newBeam []*BeamCandidate
+		var parents []int
+		for _, exp := range expansions {
+			parentTokens := beam[exp.Parent].Tokens
+			if exp.Token == 0 {
+				done = append(done, &BeamCandidate{
+					Tokens:  parentTokens,
+					LogProb: exp.LogProb,
+				})
+				continue
+			}
+			newTokens := append(append([]int{}, parentTokens...), exp.Token)
+			newBeam = append(newBeam, &BeamCandidate{
+				Tokens:  newTokens,
+				LogProb: exp.LogProb,
+			})
+			parents = append(parents, exp.Parent)
+		}
+
+		beam = newBeam
+		if len(beam) == 0 || beamFinished(done, beam[0].LogProb, beamSize) {
+			break
+		}
+		state = d.gatherState(next.State(), len(logProbs)/size, parents)
+	}
+
+	sort.Slice(done, func(i, j int) bool {
+		return done[i].LogProb > done[j].LogProb
+	})
+	if len(done) > beamSize {
+		done = done[:beamSize]
+	}
+	for _, cand := range done {
+		cand.Bytes = vocab.Detokenize(cand.Tokens)
+	}
+	return done
+}
+
+// gatherState creates a new batch of decoder states by
+// selecting entries from an existing batch.
+//
+// Each entry of indices is the index of an entry in the
+// original batch.
+// Entries may be repeated or omitted.
+func (d *Decoder) gatherState(s anyrnn.State, batchSize int, indices []int) anyrnn.State {
+	outState := make(anyrnn.StackState, len(d.Block))
+	for i, layer := range d.Block {
+		lstm, ok := layer.(*anyrnn.LSTM)
+		if !ok {
+			outState[i] = layer.Start(len(indices))
+			continue
+		}
+		old := s.(anyrnn.StackState)[i].(*anyrnn.LSTMState)
+		start := lstm.Start(len(indices)).(*anyrnn.LSTMState)
+		start.Internal.Vector = gatherRows(old.Internal.Vector, batchSize, indices)
+		start.LastOut.Vector = gatherRows(old.LastOut.Vector, batchSize, indices)
+		outState[i] = start
+	}
+	return outState
+}
+
+type beamExpansion struct {
+	Parent  int
+	Token   int
+	LogProb float64
+}
+
+// beamFinished checks if no partial string in the beam
+// could ever beat the finished candidates.
+//
+// Since log-probabilities only decrease as strings are
+// extended, the beam is done once it has enough finished
+// candidates that are all better than the best partial
+// string.
+func beamFinished(done []*BeamCandidate, bestPartial float64, beamSize int) bool {
+	if len(done) < beamSize {
+		return false
+	}
+	var numBetter int
+	for _, cand := range done {
+		if cand.LogProb >= bestPartial {
+			numBetter++
+		}
+	}
+	return numBetter >= beamSize
+}
+
+func gatherRows(vec anyvec.Vector, numRows int, indices []int) anyvec.Vector {
+	cols := vec.Len() / numRows
+	rows := make([]anyvec.Vector, len(indices))
+	for i, idx := range indices {
+		rows[i] = vec.Slice(cols*idx, cols*(idx+1))
+	}
+	return vec.Creator().Concat(rows...)
+}
@@ -0,0 +1,260 @@
+package tweetenc
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/unixpickle/serializer"
+)
+
+func init() {
+	var b BPEVocabulary
+	serializer.RegisterTypedDeserializer(b.SerializerType(), DeserializeBPEVocabulary)
+}
+
+// bpeNumBase is the number of tokens in a BPEVocabulary
+// that are not produced by merges: the terminator and one
+// token per byte.
+const bpeNumBase = 0x101
+
+// A BPEVocabulary is a Vocabulary of subword units
+// learned with byte-pair encoding.
+//
+// Token 0 is the terminator, token b+1 is the byte b, and
+// every other token is the concatenation of two earlier
+// tokens.
+// Since every byte has its own token, detokenizing the
+// tokens of any string gives back the exact same bytes.
+//
+// Strings are split into chunks before each space, and
+// tokens never span multiple chunks.
+type BPEVocabulary struct {
+	// Merges lists the pairs of tokens which are merged,
+	// in the order they were learned.
+	// Merging Merges[i] produces token i+0x101.
+	Merges [][2]int
+
+	ranks  map[[2]int]int
+	pieces [][]byte
+}
+
+// NewBPEVocabulary learns up to numMerges merges from
+// the samples.
+//
+// Each merge combines the most frequent pair of adjacent
+// tokens.
+// Learning stops early once no pair occurs more than
+// once.
+func NewBPEVocabulary(s SampleList, numMerges int) *BPEVocabulary {
+	chunkCounts := map[string]int{}
+	for _, sample := range s {
+		for _, chunk := range bpeChunks(sample) {
+			chunkCounts[string(chunk)]++
+		}
+	}
+	var chunks [][]int
+	var counts []int
+	for chunk, count := range chunkCounts {
+		chunks = append(chunks, bpeByteTokens([]byte(chunk)))
+		counts = append(counts, count)
+	}
+
+	pairCounts := map[[2]int]int{}
+	pairChunks := map[[2]int][]int{}
+	for i, chunk := range chunks {
+		for pair, n := range chunkPairs(chunk) {
+			pairCounts[pair] += n * counts[i]
+			pairChunks[pair] = append(pairChunks[pair], i)
+		}
+	}
+
+	res := &BPEVocabulary{}
+	for len(res.Merges) < numMerges {
+		var best [2]int
+		var bestCount int
+		for pair, count := range pairCounts {
+			if count > bestCount || (count == bestCount && pairLess(pair, best)) {
+				best, bestCount = pair, count
+			}
+		}
+		if bestCount < 2 {
+			break
+		}
+		token := bpeNumBase + len(res.Merges)
+		res.Merges = append(res.Merges, best)
+
+		// Only the chunks containing the pair change, so
+		// only their pairs are recounted.
+		for _, i := range pairChunks[best] {
+			oldPairs := chunkPairs(chunks[i])
+			chunks[i] = bpeMerge(chunks[i], best, token)
+			for pair, n := range oldPairs {
+				pairCounts[pair] -= n * counts[i]
+				if pairCounts[pair] == 0 {
+					delete(pairCounts, pair)
+				}
+			}
+			for pair, n := range chunkPairs(chunks[i]) {
+				pairCounts[pair] += n * counts[i]
+				if oldPairs[pair] == 0 {
+					pairChunks[pair] = append(pairChunks[pair], i)
+				}
+			}
+		}
+		delete(pairChunks, best)
+	}
+	res.buildTables()
+	return res
+}
+
+// DeserializeBPEVocabulary deserializes a BPEVocabulary.
+func DeserializeBPEVocabulary(d []byte) (*BPEVocabulary, error) {
+	var data []byte
+	if err := serializer.DeserializeAny(d, &data); err != nil {
+		return nil, errors.New("deserialize BPEVocabulary: " + err.Error())
+	}
+	if len(data)%8 != 0 {
+		return nil, errors.New("deserialize BPEVocabulary: invalid merge data")
+	}
+	res := &BPEVocabulary{}
+	for i := 0; i < len(data); i += 8 {
+		merge := [2]int{
+			int(binary.LittleEndian.Uint32(data[i:])),
+			int(binary.LittleEndian.Uint32(data[i+4:])),
+		}
+		limit := bpeNumBase + len(res.Merges)
+		if merge[0] < 1 || merge[1] < 1 || merge[0] >= limit || merge[1] >= limit {
+			return nil, errors.New("deserialize BPEVocabulary: invalid merge data")
+		}
+		res.Merges = append(res.Merges, merge)
+	}
+	res.buildTables()
+	return res, nil
+}
+
+// Size returns the number of tokens.
+func (b *BPEVocabulary) Size() int {
+	return bpeNumBase + len(b.Merges)
+}
+
+// Tokenize splits the string into chunks and applies the
+// merges to each chunk in the order they were learned.
+func (b *BPEVocabulary) Tokenize(data []byte) []int {
+	if b.ranks == nil {
+		b.buildTables()
+	}
+	var res []int
+	for _, chunk := range bpeChunks(data) {
+		tokens := bpeByteTokens(chunk)
+		for len(tokens) > 1 {
+			bestRank := -1
+			for i := 1; i < len(tokens); i++ {
+				rank, ok := b.ranks[[2]int{tokens[i-1], tokens[i]}]
+				if ok && (bestRank == -1 || rank < bestRank) {
+					bestRank = rank
+				}
+			}
+			if bestRank == -1 {
+				break
+			}
+			tokens = bpeMerge(tokens, b.Merges[bestRank], bestRank+bpeNumBase)
+		}
+		res = append(res, tokens...)
+	}
+	return res
+}
+
+// Detokenize concatenates the bytes of the tokens.
+func (b *BPEVocabulary) Detokenize(tokens []int) []byte {
+	if b.pieces == nil {
+		b.buildTables()
+	}
+	res := []byte{}
+	for _, token := range tokens {
+		res = append(res, b.pieces[token]...)
+	}
+	return res
+}
+
+// SerializerType returns the unique ID used to serialize
+// a BPEVocabulary with the serializer package.
+func (b *BPEVocabulary) SerializerType() string {
+	return "github.com/unixpickle/tweetenc.BPEVocabulary"
+}
+
+// Serialize serializes the BPEVocabulary.
+func (b *BPEVocabulary) Serialize() ([]byte, error) {
+	data := make([]byte, 8*len(b.Merges))
+	for i, merge := range b.Merges {
+		binary.LittleEndian.PutUint32(data[8*i:], uint32(merge[0]))
+		binary.LittleEndian.PutUint32(data[8*i+4:], uint32(merge[1]))
+	}
+	return serializer.SerializeAny(data)
+}
+
+func (b *BPEVocabulary) buildTables() {
+	b.ranks = map[[2]int]int{}
+	b.pieces = make([][]byte, bpeNumBase, b.Size())
+	b.pieces[0] = []byte{}
+	for i := 1; i < bpeNumBase; i++ {
+		b.pieces[i] = []byte{byte(i - 1)}
+	}
+	for i, merge := range b.Merges {
+		b.ranks[merge] = i
+		piece := append(append([]byte{}, b.pieces[merge[0]]...), b.pieces[merge[1]]...)
+		b.pieces = append(b.pieces, piece)
+	}
+}
+
+// bpeChunks splits a string before every space.
+func bpeChunks(data []byte) [][]byte {
+	var res [][]byte
+	var start int
+	for i := 1; i < len(data); i++ {
+		if data[i] == ' ' {
+			res = append(res, data[start:i])
+			start = i
+		}
+	}
+	if start < len(data) {
+		res = append(res, data[start:])
+	}
+	return res
+}
+
+func bpeByteTokens(data []byte) []int {
+	res := make([]int, len(data))
+	for i, x := range data {
+		res[i] = int(x) + 1
+	}
+	return res
+}
+
+// bpeMerge replaces every occurrence of the pair, from
+// left to right, with the merged token.
+func bpeMerge(tokens []int, pair [2]int, merged int) []int {
+	res := make([]int, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		if i+1 < len(tokens) && tokens[i] == pair[0] && tokens[i+1] == pair[1] {
+			res = append(res, merged)
+			i++
+		} else {
+			res = append(res, tokens[i])
+		}
+	}
+	return res
+}
+
+// chunkPairs counts the occurrences of each pair of
+// adjacent tokens in a chunk.
+func chunkPairs(chunk []int) map[[2]int]int {
+	res := map[[2]int]int{}
+	for i := 1; i < len(chunk); i++ {
+		res[[2]int{chunk[i-1], chunk[i]}]++
+	}
+	return res
+}
+
+func pairLess(p1, p2 [2]int) bool {
+	return p1[0] < p2[0] || (p1[0] == p2[0] && p1[1] < p2[1])
+}
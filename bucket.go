@@ -0,0 +1,111 @@
+package tweetenc
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/unixpickle/anynet/anysgd"
+)
+
+// A BucketList is an anysgd.SampleList whose elements are
+// entire batches of samples with similar lengths.
+//
+// Grouping samples by length reduces the number of padded
+// timesteps in each batch.
+// The batches of a BucketList are fixed, and anysgd.SGD
+// only shuffles their order.
+// To change which samples are batched together, build a
+// new BucketList every epoch, as EpochOrder does.
+//
+// To train on a BucketList, use a batch size of 1.
+// Trainer.Fetch accepts BucketLists directly.
+type BucketList []SampleList
+
+// NewBucketList groups samples of similar lengths into
+// batches of batchSize samples.
+//
+// Samples with equal lengths are shuffled with gen before
+// being grouped, so that batches are not determined by
+// the original order of the samples.
+// If gen is nil, the global math/rand source is used.
+func NewBucketList(s SampleList, batchSize int, gen *rand.Rand) BucketList {
+	var perm []int
+	if gen == nil {
+		perm = rand.Perm(len(s))
+	} else {
+		perm = gen.Perm(len(s))
+	}
+	sorted := make(SampleList, len(s))
+	for i, j := range perm {
+		sorted[i] = s[j]
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i]) < len(sorted[j])
+	})
+
+	var res BucketList
+	for i := 0; i < len(sorted); i += batchSize {
+		end := i + batchSize
+		if end > len(sorted) {
+			end = len(sorted)
+		}
+		res = append(res, sorted[i:end])
+	}
+	return res
+}
+
+// Len returns the number of batches.
+func (b BucketList) Len() int {
+	return len(b)
+}
+
+// Swap swaps two batches.
+func (b BucketList) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+// Slice returns a shallow copy of a range of batches.
+func (b BucketList) Slice(start, end int) anysgd.SampleList {
+	return append(BucketList{}, b[start:end]...)
+}
+
+// Samples returns all of the samples in all the batches.
+func (b BucketList) Samples() SampleList {
+	var res SampleList
+	for _, batch := range b {
+		res = append(res, batch...)
+	}
+	return res
+}
+
+// PaddingEfficiency computes the fraction of timesteps in
+// the batches which are not padding.
+func (b BucketList) PaddingEfficiency() float64 {
+	var used, total int
+	for _, batch := range b {
+		var maxLen int
+		for _, sample := range batch {
+			used += len(sample) + 1
+			if len(sample)+1 > maxLen {
+				maxLen = len(sample) + 1
+			}
+		}
+		total += maxLen * len(batch)
+	}
+	return float64(used) / float64(total)
+}
+
+// PaddingEfficiency computes the fraction of timesteps
+// which would not be padding if the samples were split
+// into consecutive batches of batchSize samples.
+func (s SampleList) PaddingEfficiency(batchSize int) float64 {
+	var batches BucketList
+	for i := 0; i < len(s); i += batchSize {
+		end := i + batchSize
+		if end > len(s) {
+			end = len(s)
+		}
+		batches = append(batches, s[i:end])
+	}
+	return batches.PaddingEfficiency()
+}
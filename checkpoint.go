@@ -0,0 +1,125 @@
+package tweetenc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/unixpickle/serializer"
+)
+
+func init() {
+	var c Checkpoint
+	serializer.RegisterTypedDeserializer(c.SerializerType(), DeserializeCheckpoint)
+}
+
+// A Checkpoint stores the state of a training run, from
+// which training can be resumed.
+type Checkpoint struct {
+	Model *Model
+
+	// Iteration is the number of training iterations that
+	// had been completed when the checkpoint was saved.
+	Iteration int
+
+	// Optimizer is the state of the optimizer.
+	// It may be nil if the optimizer is stateless.
+	Optimizer *AdamState
+
+	// RandState is the state of the RandSource used by
+	// the Trainer.
+	RandState uint64
+
+	// ShuffleSeed is the EpochOrder seed which determines
+	// the order of the training samples.
+	ShuffleSeed int64
+}
+
+// DeserializeCheckpoint deserializes a Checkpoint.
+func DeserializeCheckpoint(d []byte) (*Checkpoint, error) {
+	var res Checkpoint
+	var hasOptimizer bool
+	var optimizerData, randData []byte
+	err := serializer.DeserializeAny(d, &res.Model, &res.Iteration,
+		&hasOptimizer, &optimizerData, &randData)
+	if err != nil {
+		return nil, errors.New("deserialize Checkpoint: " + err.Error())
+	}
+	if hasOptimizer {
+		res.Optimizer, err = DeserializeAdamState(optimizerData)
+		if err != nil {
+			return nil, errors.New("deserialize Checkpoint: " + err.Error())
+		}
+	}
+	if len(randData) != 16 {
+		return nil, errors.New("deserialize Checkpoint: invalid random state")
+	}
+	res.RandState = binary.LittleEndian.Uint64(randData)
+	res.ShuffleSeed = int64(binary.LittleEndian.Uint64(randData[8:]))
+	return &res, nil
+}
+
+// SerializerType returns the unique ID used to serialize
+// a Checkpoint with the serializer package.
+func (c *Checkpoint) SerializerType() string {
+	return "github.com/unixpickle/tweetenc.Checkpoint"
+}
+
+// Serialize serializes the Checkpoint.
+func (c *Checkpoint) Serialize() ([]byte, error) {
+	var optimizerData []byte
+	if c.Optimizer != nil {
+		var err error
+		optimizerData, err = c.Optimizer.Serialize()
+		if err != nil {
+			return nil, err
+		}
+	}
+	randData := make([]byte, 16)
+	binary.LittleEndian.PutUint64(randData, c.RandState)
+	binary.LittleEndian.PutUint64(randData[8:], uint64(c.ShuffleSeed))
+	return serializer.SerializeAny(c.Model, c.Iteration, c.Optimizer != nil,
+		optimizerData, randData)
+}
+
+// SaveAtomic is like serializer.SaveAny, except that the
+// file is never left partially written.
+//
+// The data is first written to a temporary file in the
+// same directory, which is then renamed to path.
+func SaveAtomic(path string, objs ...interface{}) error {
+	data, err := serializer.SerializeAny(objs...)
+	if err != nil {
+		return err
+	}
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package tweetenc
+
+import "math/rand"
+
+// A Corruption describes random noise which can be added
+// to samples.
+//
+// Each field is the probability of the corresponding edit
+// at each position in a sample.
+// Random bytes are always printable ASCII characters.
+type Corruption struct {
+	// Substitute replaces bytes with random bytes.
+	Substitute float64
+
+	// Delete removes bytes.
+	Delete float64
+
+	// Insert adds random bytes after bytes.
+	Insert float64
+
+	// Swap exchanges bytes with the bytes after them.
+	Swap float64
+
+	// CaseFlip changes the case of ASCII letters.
+	CaseFlip float64
+}
+
+// Apply produces a corrupted copy of a sample.
+//
+// The result is never empty, even if every byte of the
+// sample was deleted.
+//
+// If gen is nil, the global math/rand source is used.
+func (c *Corruption) Apply(sample []byte, gen *rand.Rand) []byte {
+	res := append([]byte{}, sample...)
+	for i := 0; i+1 < len(res); i++ {
+		if genFloat(gen) < c.Swap {
+			res[i], res[i+1] = res[i+1], res[i]
+			i++
+		}
+	}
+
+	var edited []byte
+	for _, b := range res {
+		if genFloat(gen) < c.Delete {
+			continue
+		}
+		if genFloat(gen) < c.Substitute {
+			b = randomPrintable(gen)
+		}
+		if genFloat(gen) < c.CaseFlip {
+			if b >= 'a' && b <= 'z' {
+				b += 'A' - 'a'
+			} else if b >= 'A' && b <= 'Z' {
+				b += 'a' - 'A'
+			}
+		}
+		edited = append(edited, b)
+		if genFloat(gen) < c.Insert {
+			edited = append(edited, randomPrintable(gen))
+		}
+	}
+
+	if len(edited) == 0 {
+		edited = []byte{randomPrintable(gen)}
+	}
+	return edited
+}
+
+func randomPrintable(gen *rand.Rand) byte {
+	const first, last = 0x20, 0x7e
+	if gen == nil {
+		return byte(first + rand.Intn(last-first+1))
+	}
+	return byte(first + gen.Intn(last-first+1))
+}
+
+func genFloat(gen *rand.Rand) float64 {
+	if gen == nil {
+		return rand.Float64()
+	}
+	return gen.Float64()
+}
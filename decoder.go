@@ -26,37 +26,44 @@ type Decoder struct {
 	// StateMapper maps the vectors from the encoder to state
 	// vectors for the decoder block.
 	StateMapper anynet.Layer
+
+	// Vocab converts between strings and the tokens which
+	// Block consumes and predicts.
+	// If nil, a ByteVocabulary is used.
+	Vocab Vocabulary
 }
 
 // DeserializeDecoder deserializes a Decoder.
 func DeserializeDecoder(d []byte) (*Decoder, error) {
-	var b anyrnn.Stack
-	var m anynet.Layer
-	if err := serializer.DeserializeAny(d, &b, &m); err != nil {
+	list, err := serializer.DeserializeSlice(d)
+	if err != nil {
 		return nil, errors.New("deserialize Decoder: " + err.Error())
 	}
-	return &Decoder{Block: b, StateMapper: m}, nil
+	if len(list) != 2 && len(list) != 3 {
+		return nil, errors.New("deserialize Decoder: unexpected number of fields")
+	}
+	block, ok1 := list[0].(anyrnn.Stack)
+	mapper, ok2 := list[1].(anynet.Layer)
+	if !ok1 || !ok2 {
+		return nil, errors.New("deserialize Decoder: unexpected field types")
+	}
+	res := &Decoder{Block: block, StateMapper: mapper}
+
+	// Decoders without vocabularies use bytes.
+	if len(list) == 3 {
+		switch obj := list[2].(type) {
+		case Vocabulary:
+			res.Vocab = obj
+		default:
+			return nil, errors.New("deserialize Decoder: unexpected field types")
+		}
+	}
+	return res, nil
 }
 
 // NewDecoder creates a Decoder with a default structure.
 func NewDecoder(c anyvec.Creator, encodedSize, stateSize int) *Decoder {
-	scaler := c.MakeNumeric(16)
-	return &Decoder{
-		Block: anyrnn.Stack{
-			anyrnn.NewLSTM(c, 0x100, stateSize).ScaleInWeights(scaler),
-			anyrnn.NewLSTM(c, stateSize, stateSize).ScaleInWeights(scaler),
-			anyrnn.NewLSTM(c, stateSize, stateSize).ScaleInWeights(scaler),
-			&anyrnn.LayerBlock{
-				Layer: anynet.Net{
-					anynet.NewFC(c, stateSize, 0x100),
-					anynet.LogSoftmax,
-				},
-			},
-		},
-		StateMapper: anynet.Net{
-			anynet.NewFC(c, encodedSize, stateSize*6),
-		},
-	}
+	return NewDecoderArch(c, DefaultArchitecture(encodedSize, stateSize), nil)
 }
 
 // Guided decodes the batch of vectors and produces
@@ -77,25 +84,144 @@ func (d *Decoder) Guided(encoded anydiff.Res, guide anyseq.Seq, batchSize int) a
 	return anyrnn.MapWithStart(guide, d.Block, start, startProp)
 }
 
+// LogLikelihoods computes how likely each of the byte
+// strings is under the corresponding feature vector in a
+// packed batch of feature vectors.
+//
+// For each string, total is the log-probability of the
+// entire string and its null-terminator.
+// The log-probability of every token is spread evenly
+// over the bytes it produces and stored in perByte, where
+// the final entry for each string corresponds to the
+// null-terminator.
+// For vocabularies which reproduce strings exactly, like
+// ByteVocabulary and BPEVocabulary, each string therefore
+// gets one entry per byte plus one.
+func (d *Decoder) LogLikelihoods(encoded anyvec.Vector, samples [][]byte) (total []float64,
+	perByte [][]float64) {
+	c := encoded.Creator()
+	vocab := d.vocab()
+	_, guide := teacherForcedSeqs(c, vocab, samples)
+	decoded := d.Guided(anydiff.NewConst(encoded), anyseq.ConstSeqList(c, guide),
+		len(samples))
+
+	tokens := make([][]int, len(samples))
+	for i, sample := range samples {
+		tokens[i] = vocab.Tokenize(sample)
+	}
+
+	perToken := make([][]float64, len(samples))
+	for _, batch := range decoded.Output() {
+		outputs := vectorData(batch.Packed)
+		var packedIdx int
+		for i, present := range batch.Present {
+			if !present {
+				continue
+			}
+			var target int
+			if t := len(perToken[i]); t < len(tokens[i]) {
+				target = tokens[i][t]
+			}
+			perToken[i] = append(perToken[i], outputs[packedIdx*vocab.Size()+target])
+			packedIdx++
+		}
+	}
+
+	total = make([]float64, len(samples))
+	perByte = make([][]float64, len(samples))
+	for i, probs := range perToken {
+		for t, p := range probs {
+			total[i] += p
+			if t == len(tokens[i]) {
+				perByte[i] = append(perByte[i], p)
+				continue
+			}
+			numBytes := len(vocab.Detokenize(tokens[i][t : t+1]))
+			for j := 0; j < numBytes; j++ {
+				perByte[i] = append(perByte[i], p/float64(numBytes))
+			}
+		}
+	}
+	return
+}
+
 // Unguided reconstructs a sequence from a feature vector
 // without any guiding input sequence.
-func (d *Decoder) Unguided(encoded anyvec.Vector) []byte {
-	mapped := d.StateMapper.Apply(anydiff.NewConst(encoded), 1)
-	state := d.vecToState(mapped.Output(), 1)
-	input := oneHot(encoded.Creator(), 0)
-	res := []byte{}
-	for {
-		next := d.Block.Step(state, input)
-		state = next.State()
+//
+// The output is cut off after maxLen tokens.
+func (d *Decoder) Unguided(encoded anyvec.Vector, maxLen int) []byte {
+	res, _ := d.UnguidedBatch(encoded, 1, maxLen)
+	return res[0]
+}
 
-		max := byte(anyvec.MaxIndex(next.Output()))
-		if max == 0 {
+// UnguidedBatch reconstructs a batch of sequences from a
+// packed batch of feature vectors, like the ones produced
+// by Encoder.Encode.
+//
+// Each sequence ends at its own null-terminator or after
+// maxLen tokens, whichever comes first.
+// For each sequence, truncated indicates whether or not
+// it was cut off at maxLen tokens.
+func (d *Decoder) UnguidedBatch(encoded anyvec.Vector, batchSize,
+	maxLen int) (res [][]byte, truncated []bool) {
+	c := encoded.Creator()
+	vocab := d.vocab()
+	size := vocab.Size()
+	mapped := d.StateMapper.Apply(anydiff.NewConst(encoded), batchSize)
+	state := d.vecToState(mapped.Output(), batchSize)
+
+	tokens := make([][]int, batchSize)
+	truncated = make([]bool, batchSize)
+	active := make([]int, batchSize)
+	inputs := make([]anyvec.Vector, batchSize)
+	for i := range active {
+		active[i] = i
+		inputs[i] = oneHot(c, size, 0)
+	}
+
+	for numSteps := 0; len(active) > 0; numSteps++ {
+		if numSteps >= maxLen {
+			for _, idx := range active {
+				truncated[idx] = true
+			}
 			break
 		}
-		res = append(res, max)
-		input = oneHot(encoded.Creator(), max)
+
+		next := d.Block.Step(state, c.Concat(inputs...))
+		state = next.State()
+		outputs := vectorData(next.Output())
+
+		present := append(anyrnn.PresentMap{}, state.Present()...)
+		var newActive []int
+		var newInputs []anyvec.Vector
+		for i, idx := range active {
+			max := argMax(outputs[i*size : (i+1)*size])
+			if max == 0 {
+				present[idx] = false
+				continue
+			}
+			tokens[idx] = append(tokens[idx], max)
+			newActive = append(newActive, idx)
+			newInputs = append(newInputs, oneHot(c, size, max))
+		}
+		if len(newActive) > 0 && len(newActive) < len(active) {
+			state = state.Reduce(present)
+		}
+		active = newActive
+		inputs = newInputs
+	}
+
+	res = make([][]byte, batchSize)
+	for i, seq := range tokens {
+		res[i] = vocab.Detokenize(seq)
 	}
-	return res
+	return
+}
+
+// Parameters returns the parameters of every part of the
+// Decoder, in a consistent order.
+func (d *Decoder) Parameters() []*anydiff.Var {
+	return parameters(d.Block, d.StateMapper)
 }
 
 // SerializerType returns the unique ID used to serialize
@@ -106,7 +232,17 @@ func (d *Decoder) SerializerType() string {
 
 // Serialize serializes the Decoder.
 func (d *Decoder) Serialize() ([]byte, error) {
-	return serializer.SerializeAny(d.Block, d.StateMapper)
+	if d.Vocab == nil {
+		return serializer.SerializeAny(d.Block, d.StateMapper)
+	}
+	return serializer.SerializeAny(d.Block, d.StateMapper, d.Vocab)
+}
+
+func (d *Decoder) vocab() Vocabulary {
+	if d.Vocab == nil {
+		return ByteVocabulary{}
+	}
+	return d.Vocab
 }
 
 func (d *Decoder) vecToState(vec anyvec.Vector, batchSize int) anyrnn.State {
@@ -147,6 +283,16 @@ func (d *Decoder) stateToVec(s anyrnn.StateGrad, batchSize int) anyvec.Vector {
 	return perBatch[0].Creator().Concat(perBatch...)
 }
 
+func argMax(vals []float64) int {
+	var maxIdx int
+	for i, x := range vals {
+		if x > vals[maxIdx] {
+			maxIdx = i
+		}
+	}
+	return maxIdx
+}
+
 func moveIntoVecState(packed []anyvec.Vector, v *anyrnn.VecState) {
 	sliceAmount := v.Vector.Len() / len(packed)
 	var joinMe []anyvec.Vector
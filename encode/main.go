@@ -8,31 +8,40 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"time"
 
 	"github.com/unixpickle/essentials"
-	"github.com/unixpickle/serializer"
 	"github.com/unixpickle/tweetenc"
 )
 
 func main() {
 	var dataFile string
 	var outFile string
+	var modelFile string
 	var encFile string
 	var batchSize int
+	var seed int64
 
 	flag.StringVar(&dataFile, "data", "", "input CSV file")
 	flag.StringVar(&outFile, "out", "out.csv", "output CSV file")
-	flag.StringVar(&encFile, "encoder", "../train/enc_out", "encoder file")
+	flag.StringVar(&modelFile, "model", "../train/model_out", "model file")
+	flag.StringVar(&encFile, "encoder", "../train/enc_out", "legacy encoder file")
 	flag.IntVar(&batchSize, "batch", 8, "computation batch size")
+	flag.Int64Var(&seed, "seed", 0, "random seed for the output order (0 to seed from the time)")
 	flag.Parse()
 
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	gen := rand.New(tweetenc.NewRandSource(seed))
+
 	if dataFile == "" {
 		essentials.Die("Missing -data flag. See -help for more info.")
 	}
 
 	log.Println("Loading encoder...")
-	var enc *tweetenc.Encoder
-	if err := serializer.LoadAny(encFile, &enc); err != nil {
+	enc, err := tweetenc.LoadEncoderFiles(modelFile, encFile)
+	if err != nil {
 		essentials.Die("Load encoder:", err)
 	}
 
@@ -47,7 +56,7 @@ func main() {
 	if err != nil {
 		essentials.Die("Read data:", err)
 	}
-	dataPerm := rand.Perm(len(dataContents))
+	dataPerm := gen.Perm(len(dataContents))
 
 	log.Println("Opening output file...")
 	dataWriter, err := os.Create(outFile)
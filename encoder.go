@@ -30,50 +30,82 @@ func init() {
 
 // An Encoder encodes strings of bytes into vectors.
 type Encoder struct {
-	Block         anyrnn.Block
+	// Block reads each string from end to start.
+	Block anyrnn.Block
+
+	// Forward, if non-nil, reads each string from start
+	// to end, making the Encoder bidirectional.
+	// The final outputs of Block and Forward are
+	// concatenated before being fed to MeanEncoder and
+	// StddevEncoder.
+	Forward anyrnn.Block
+
+	// Pool, if non-nil, summarizes the outputs from every
+	// timestep of each block.
+	// If nil, only the final outputs are used.
+	// The same Pool is used for Block and Forward.
+	Pool Pooler
+
+	// Vocab converts strings to input tokens.
+	// If nil, a ByteVocabulary is used.
+	Vocab Vocabulary
+
 	MeanEncoder   anynet.Layer
 	StddevEncoder anynet.Layer
 }
 
 // DeserializeEncoder deserializes an Encoder.
 func DeserializeEncoder(d []byte) (*Encoder, error) {
-	var block anyrnn.Block
-	var mean, stddev anynet.Layer
-	if err := serializer.DeserializeAny(d, &block, &mean, &stddev); err != nil {
+	list, err := serializer.DeserializeSlice(d)
+	if err != nil {
 		return nil, errors.New("deserialize Encoder: " + err.Error())
 	}
-	return &Encoder{
+	if len(list) < 3 {
+		return nil, errors.New("deserialize Encoder: missing fields")
+	}
+	block, ok1 := list[0].(anyrnn.Block)
+	mean, ok2 := list[1].(anynet.Layer)
+	stddev, ok3 := list[2].(anynet.Layer)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("deserialize Encoder: unexpected field types")
+	}
+	res := &Encoder{
 		Block:         block,
 		MeanEncoder:   mean,
 		StddevEncoder: stddev,
-	}, nil
+	}
+
+	// Optional fields are identified by their types.
+	for _, obj := range list[3:] {
+		switch obj := obj.(type) {
+		case Pooler:
+			res.Pool = obj
+		case Vocabulary:
+			res.Vocab = obj
+		case anyrnn.Block:
+			res.Forward = obj
+		default:
+			return nil, errors.New("deserialize Encoder: unexpected field types")
+		}
+	}
+	return res, nil
 }
 
-// NewEncoder creates an Encoder.
+// NewEncoder creates an Encoder with the default
+// architecture.
 func NewEncoder(c anyvec.Creator, encodedSize, stateSize int) *Encoder {
-	scaler := c.MakeNumeric(16)
-	stddevLayer := anynet.NewFC(c, stateSize, encodedSize)
-	stddevLayer.Biases.Vector.AddScalar(c.MakeNumeric(initStddevBias))
-	return &Encoder{
-		Block: anyrnn.Stack{
-			anyrnn.NewLSTM(c, 0x100, stateSize).ScaleInWeights(scaler),
-			anyrnn.NewLSTM(c, stateSize, stateSize).ScaleInWeights(scaler),
-			anyrnn.NewLSTM(c, stateSize, stateSize).ScaleInWeights(scaler),
-		},
-		MeanEncoder: anynet.Net{
-			anynet.NewFC(c, stateSize, encodedSize),
-		},
-		StddevEncoder: anynet.Net{
-			stddevLayer,
-		},
-	}
+	return NewEncoderArch(c, DefaultArchitecture(encodedSize, stateSize), nil)
 }
 
-// Apply applies the encoder to an input sequence, which
-// should be reversed and should lack a null-terminator.
+// Apply applies the encoder to an input sequence of
+// one-hot tokens, which should be reversed and should
+// lack a null-terminator.
 //
 // There must be at least one sequence, and all sequences
 // must be non-empty.
+// If the Encoder is bidirectional, the input sequence
+// must not require gradients, since it is re-ordered
+// without propagating through it.
 //
 // The resulting mean and log-scale standard deviations
 // are returned, in that order.
@@ -84,15 +116,18 @@ func (e *Encoder) Apply(s anyseq.Seq) anydiff.MultiRes {
 	if s.Output()[0].NumPresent() != len(s.Output()[0].Present) {
 		panic("input sequences must be non-empty")
 	}
-	outSeq := anyrnn.Map(s, e.Block)
-	tail := anyseq.Tail(outSeq)
+	summary := e.summarize(anyrnn.Map(s, e.Block))
+	if e.Forward != nil {
+		forwardSummary := e.summarize(anyrnn.Map(reverseSeqs(s), e.Forward))
+		summary = concatRows(summary, forwardSummary, s.Output()[0].NumPresent())
+	}
 
-	temp := anydiff.Fuse(tail)
+	temp := anydiff.Fuse(summary)
 	return anydiff.PoolMulti(temp, func(reses []anydiff.Res) anydiff.MultiRes {
-		tail = reses[0]
+		summary := reses[0]
 		n := s.Output()[0].NumPresent()
-		means := e.MeanEncoder.Apply(tail, n)
-		stddevs := e.StddevEncoder.Apply(tail, n)
+		means := e.MeanEncoder.Apply(summary, n)
+		stddevs := e.StddevEncoder.Apply(summary, n)
 		return anydiff.Fuse(means, stddevs)
 	})
 }
@@ -102,11 +137,12 @@ func (e *Encoder) Apply(s anyseq.Seq) anydiff.MultiRes {
 func (e *Encoder) Encode(samples ...string) (mean, logStddev anyvec.Vector) {
 	var inSeqs [][]anyvec.Vector
 	cr := e.Block.(anynet.Parameterizer).Parameters()[0].Vector.Creator()
+	vocab := e.vocab()
 	for _, s := range samples {
 		inSeq := []anyvec.Vector{}
-		byteString := []byte(s)
-		for i := len(byteString) - 1; i >= 0; i-- {
-			inSeq = append(inSeq, oneHot(cr, byteString[i]))
+		tokens := vocab.Tokenize([]byte(s))
+		for i := len(tokens) - 1; i >= 0; i-- {
+			inSeq = append(inSeq, oneHot(cr, vocab.Size(), tokens[i]))
 		}
 		inSeqs = append(inSeqs, inSeq)
 	}
@@ -115,6 +151,12 @@ func (e *Encoder) Encode(samples ...string) (mean, logStddev anyvec.Vector) {
 	return out[0], out[1]
 }
 
+// Parameters returns the parameters of every part of the
+// Encoder, in a consistent order.
+func (e *Encoder) Parameters() []*anydiff.Var {
+	return parameters(e.Block, e.Forward, e.Pool, e.MeanEncoder, e.StddevEncoder)
+}
+
 // SerializerType returns the unique ID used to serialize
 // an Encoder with the serializer package.
 func (e *Encoder) SerializerType() string {
@@ -123,9 +165,93 @@ func (e *Encoder) SerializerType() string {
 
 // Serialize serializes the Encoder.
 func (e *Encoder) Serialize() ([]byte, error) {
-	return serializer.SerializeAny(
+	list := []interface{}{
 		e.Block,
 		e.MeanEncoder,
 		e.StddevEncoder,
-	)
+	}
+	if e.Forward != nil {
+		list = append(list, e.Forward)
+	}
+	if e.Pool != nil {
+		list = append(list, e.Pool)
+	}
+	if e.Vocab != nil {
+		list = append(list, e.Vocab)
+	}
+	return serializer.SerializeAny(list...)
+}
+
+func (e *Encoder) vocab() Vocabulary {
+	if e.Vocab == nil {
+		return ByteVocabulary{}
+	}
+	return e.Vocab
+}
+
+// summarize reduces the output sequence of a recurrent
+// block to one vector per sequence.
+func (e *Encoder) summarize(outSeq anyseq.Seq) anydiff.Res {
+	if e.Pool == nil {
+		return anyseq.Tail(outSeq)
+	}
+	return e.Pool.Pool(outSeq)
+}
+
+// parameters gathers the parameters of every part which
+// is an anynet.Parameterizer.
+func parameters(parts ...interface{}) []*anydiff.Var {
+	var res []*anydiff.Var
+	for _, part := range parts {
+		if parameterizer, ok := part.(anynet.Parameterizer); ok {
+			res = append(res, parameterizer.Parameters()...)
+		}
+	}
+	return res
+}
+
+// reverseSeqs reverses every sequence in a batch.
+//
+// Gradients are not propagated through the result.
+func reverseSeqs(s anyseq.Seq) anyseq.Seq {
+	batches := s.Output()
+	c := batches[0].Packed.Creator()
+	seqs := make([][]anyvec.Vector, len(batches[0].Present))
+	for _, batch := range batches {
+		cols := batch.Packed.Len() / batch.NumPresent()
+		var packedIdx int
+		for i, present := range batch.Present {
+			if !present {
+				continue
+			}
+			vec := batch.Packed.Slice(packedIdx*cols, (packedIdx+1)*cols)
+			seqs[i] = append(seqs[i], vec)
+			packedIdx++
+		}
+	}
+	for _, seq := range seqs {
+		for i := 0; i < len(seq)/2; i++ {
+			seq[i], seq[len(seq)-(i+1)] = seq[len(seq)-(i+1)], seq[i]
+		}
+	}
+	return anyseq.ConstSeqList(c, seqs)
+}
+
+// concatRows concatenates each row of the matrix m1 with
+// the corresponding row of m2.
+func concatRows(m1, m2 anydiff.Res, rows int) anydiff.Res {
+	cols1 := m1.Output().Len() / rows
+	cols2 := m2.Output().Len() / rows
+	var table []int
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols1; j++ {
+			table = append(table, i*cols1+j)
+		}
+		for j := 0; j < cols2; j++ {
+			table = append(table, rows*cols1+i*cols2+j)
+		}
+	}
+	joined := anydiff.Concat(m1, m2)
+	mapper := joined.Output().Creator().MakeMapper(joined.Output().Len(), table)
+	return anydiff.Map(mapper, joined)
 }
@@ -0,0 +1,141 @@
+package tweetenc
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/unixpickle/anyvec"
+)
+
+// An Evaluator estimates the marginal likelihood of text
+// samples under an encoder/decoder pair.
+//
+// The marginal likelihood log p(x) is estimated with
+// importance sampling, using the encoder's posterior
+// distribution as the proposal distribution.
+// Unlike the training cost, this estimate does not
+// depend on the KL weight used during training, so it
+// can be used to compare arbitrary models.
+type Evaluator struct {
+	Encoder *Encoder
+	Decoder *Decoder
+
+	// NumDraws is the number of latent vectors sampled
+	// for each text sample.
+	// Larger values give tighter estimates.
+	// It must be at least 1.
+	NumDraws int
+
+	// BatchSize is the number of text samples to process
+	// at once.
+	// If 0, all the samples are processed at once.
+	BatchSize int
+
+	// Rand, if non-nil, is used to draw latent vectors.
+	// If nil, the global math/rand source is used.
+	Rand *rand.Rand
+}
+
+// An Evaluation stores the results of an Evaluator.
+type Evaluation struct {
+	// NumSamples is the number of text samples.
+	NumSamples int
+
+	// NumBytes is the total number of bytes in the text
+	// samples, including null-terminators.
+	NumBytes int
+
+	// NegLogLikelihood is the estimated negative
+	// log-likelihood of all the samples, in nats.
+	NegLogLikelihood float64
+}
+
+// NatsPerSample returns the average negative
+// log-likelihood of a sample.
+func (e *Evaluation) NatsPerSample() float64 {
+	return e.NegLogLikelihood / float64(e.NumSamples)
+}
+
+// BitsPerByte returns the average negative
+// log-likelihood of a byte, in bits.
+func (e *Evaluation) BitsPerByte() float64 {
+	return e.NegLogLikelihood / (float64(e.NumBytes) * math.Ln2)
+}
+
+// Evaluate estimates the marginal likelihood of the
+// samples.
+func (e *Evaluator) Evaluate(s SampleList) *Evaluation {
+	batchSize := e.BatchSize
+	if batchSize == 0 {
+		batchSize = len(s)
+	}
+	res := &Evaluation{}
+	for i := 0; i < len(s); i += batchSize {
+		batch := s[i:]
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		for j, logProb := range e.logLikelihoods(batch) {
+			res.NumSamples++
+			res.NumBytes += len(batch[j]) + 1
+			res.NegLogLikelihood -= logProb
+		}
+	}
+	return res
+}
+
+func (e *Evaluator) logLikelihoods(s SampleList) []float64 {
+	var strs []string
+	for _, sample := range s {
+		strs = append(strs, string(sample))
+	}
+	mean, logStddev := e.Encoder.Encode(strs...)
+	stddev := logStddev.Copy()
+	anyvec.Exp(stddev)
+	logStddevData := vectorData(logStddev)
+	latentSize := mean.Len() / len(s)
+
+	// weights[i][k] is the log importance weight of the
+	// k-th draw for the i-th sample.
+	weights := make([][]float64, len(s))
+
+	for k := 0; k < e.NumDraws; k++ {
+		noise := mean.Creator().MakeVector(mean.Len())
+		anyvec.Rand(noise, anyvec.Normal, e.Rand)
+		noiseData := vectorData(noise)
+		noise.Mul(stddev)
+		noise.Add(mean)
+		latentData := vectorData(noise)
+
+		decoderProbs, _ := e.Decoder.LogLikelihoods(noise, s)
+		for i, decoderProb := range decoderProbs {
+			// The normalization constants of the prior and
+			// the posterior cancel out.
+			weight := decoderProb
+			for j := latentSize * i; j < latentSize*(i+1); j++ {
+				priorProb := -0.5 * latentData[j] * latentData[j]
+				postProb := -0.5*noiseData[j]*noiseData[j] - logStddevData[j]
+				weight += priorProb - postProb
+			}
+			weights[i] = append(weights[i], weight)
+		}
+	}
+
+	res := make([]float64, len(s))
+	for i, w := range weights {
+		res[i] = logSumExp(w) - math.Log(float64(e.NumDraws))
+	}
+	return res
+}
+
+func logSumExp(vals []float64) float64 {
+	max := math.Inf(-1)
+	for _, x := range vals {
+		max = math.Max(max, x)
+	}
+	var sum float64
+	for _, x := range vals {
+		sum += math.Exp(x - max)
+	}
+	return max + math.Log(sum)
+}
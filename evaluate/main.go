@@ -0,0 +1,145 @@
+// Command evaluate estimates the marginal likelihood of
+// tweets under an encoder/decoder pair.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/unixpickle/tweetenc"
+)
+
+func main() {
+	var dataPath string
+	var modelPath string
+	var encPath string
+	var decPath string
+	var baselinePath string
+	var numSamples int
+	var numDraws int
+	var batchSize int
+	var seed int64
+	var split string
+	var valFrac float64
+	var testFrac float64
+	flag.StringVar(&dataPath, "data", "", "tweet data")
+	flag.StringVar(&modelPath, "model", "../train/model_out", "model file")
+	flag.StringVar(&encPath, "encoder", "../train/enc_out", "legacy encoder network")
+	flag.StringVar(&decPath, "decoder", "../train/dec_out", "legacy decoder network")
+	flag.StringVar(&baselinePath, "baseline", "", "model file to compare against")
+	flag.IntVar(&numSamples, "num", 512, "number of samples (0 for all)")
+	flag.IntVar(&numDraws, "draws", 16, "importance samples per tweet")
+	flag.IntVar(&batchSize, "batch", 32, "batch size")
+	flag.Int64Var(&seed, "seed", 0, "random seed (0 to seed from the time)")
+	flag.StringVar(&split, "split", "all", "samples to evaluate (all, train, val, or test)")
+	flag.Float64Var(&valFrac, "valfrac", 0, "validation fraction used for training")
+	flag.Float64Var(&testFrac, "testfrac", 0, "testing fraction used for training")
+	flag.Parse()
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Println("Using seed", seed)
+
+	if dataPath == "" {
+		fmt.Fprintln(os.Stderr, "Missing -data flag. See -help for more.")
+		os.Exit(1)
+	}
+	if numDraws < 1 {
+		fmt.Fprintln(os.Stderr, "-draws must be positive.")
+		os.Exit(1)
+	}
+
+	log.Println("Loading model...")
+	model, err := tweetenc.LoadModelFiles(modelPath, encPath, decPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load model:", err)
+		os.Exit(1)
+	}
+
+	log.Println("Loading samples...")
+	samples, err := tweetenc.ReadSampleList(dataPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	samples, err = selectSplit(samples, split, valFrac, testFrac)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	} else if len(samples) == 0 {
+		fmt.Fprintln(os.Stderr, "No samples in the", split, "split.")
+		os.Exit(1)
+	}
+	log.Println("Evaluating", len(samples), split, "samples")
+	gen := rand.New(tweetenc.NewRandSource(seed))
+	gen.Shuffle(len(samples), samples.Swap)
+	if numSamples > 0 && numSamples < len(samples) {
+		samples = samples[:numSamples]
+	}
+
+	var baseline *tweetenc.Model
+	if baselinePath != "" {
+		log.Println("Loading baseline...")
+		baseline, err = tweetenc.LoadModel(baselinePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load baseline:", err)
+			os.Exit(1)
+		}
+	}
+
+	// The model and the baseline use the same draws.
+	evalSeed := gen.Int63()
+
+	log.Println("Evaluating...")
+	res := evaluate(model, samples, numDraws, batchSize, evalSeed)
+	fmt.Printf("samples=%d bytes=%d\n", res.NumSamples, res.NumBytes)
+	fmt.Printf("nats/sample=%.4f\n", res.NatsPerSample())
+	fmt.Printf("bits/byte=%.4f\n", res.BitsPerByte())
+
+	if baseline != nil {
+		log.Println("Evaluating baseline...")
+		baseRes := evaluate(baseline, samples, numDraws, batchSize, evalSeed)
+		fmt.Printf("baseline nats/sample=%.4f (%+.4f)\n", baseRes.NatsPerSample(),
+			res.NatsPerSample()-baseRes.NatsPerSample())
+		fmt.Printf("baseline bits/byte=%.4f (%+.4f)\n", baseRes.BitsPerByte(),
+			res.BitsPerByte()-baseRes.BitsPerByte())
+	}
+}
+
+func evaluate(model *tweetenc.Model, samples tweetenc.SampleList, numDraws,
+	batchSize int, seed int64) *tweetenc.Evaluation {
+	evaluator := &tweetenc.Evaluator{
+		Encoder:   model.Encoder,
+		Decoder:   model.Decoder,
+		NumDraws:  numDraws,
+		BatchSize: batchSize,
+		Rand:      rand.New(tweetenc.NewRandSource(seed)),
+	}
+	return evaluator.Evaluate(samples)
+}
+
+// selectSplit selects the samples from one part of the
+// split made by the train command, given the same
+// fractions.
+func selectSplit(samples tweetenc.SampleList, name string, valFrac,
+	testFrac float64) (tweetenc.SampleList, error) {
+	train, val, test := samples.Split(valFrac, testFrac)
+	switch name {
+	case "all":
+		return samples, nil
+	case "train":
+		return train, nil
+	case "val":
+		return val, nil
+	case "test":
+		return test, nil
+	default:
+		return nil, errors.New("unknown split: " + name)
+	}
+}
@@ -0,0 +1,51 @@
+package tweetenc
+
+import (
+	"math"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet/anysgd"
+)
+
+// GradNorm computes the Euclidean norm of a gradient,
+// treating all of its vectors as one long vector.
+func GradNorm(g anydiff.Grad) float64 {
+	var sum float64
+	for _, v := range g {
+		sum += numericFloat(v.Dot(v))
+	}
+	return math.Sqrt(sum)
+}
+
+// A ClipGradienter wraps an anysgd.Gradienter and scales
+// down gradients whose norm exceeds MaxNorm.
+type ClipGradienter struct {
+	Gradienter anysgd.Gradienter
+
+	// MaxNorm is the maximum gradient norm.
+	// If 0, gradients are never clipped.
+	MaxNorm float64
+
+	// LastNorm is set every time Gradient is called to
+	// the norm of the original gradient.
+	LastNorm float64
+
+	// LastClippedNorm is set every time Gradient is called
+	// to the norm of the clipped gradient.
+	LastClippedNorm float64
+}
+
+// Gradient computes and clips a gradient.
+func (c *ClipGradienter) Gradient(b anysgd.Batch) anydiff.Grad {
+	res := c.Gradienter.Gradient(b)
+	c.LastNorm = GradNorm(res)
+	c.LastClippedNorm = c.LastNorm
+	if c.MaxNorm != 0 && c.LastNorm > c.MaxNorm {
+		scale := c.MaxNorm / c.LastNorm
+		for _, v := range res {
+			v.Scale(v.Creator().MakeNumeric(scale))
+		}
+		c.LastClippedNorm = c.MaxNorm
+	}
+	return res
+}
@@ -0,0 +1,82 @@
+package tweetenc
+
+import "math"
+
+// A KLSchedule determines how much effect the
+// KL-divergence term has on the cost at each iteration of
+// training.
+type KLSchedule interface {
+	KLAmount(iteration int) float64
+}
+
+// ConstKL is a KLSchedule which always uses the same
+// weight.
+type ConstKL float64
+
+// KLAmount returns the constant.
+func (c ConstKL) KLAmount(iteration int) float64 {
+	return float64(c)
+}
+
+// LinearKL is a KLSchedule which waits for Delay
+// iterations and then linearly anneals the weight from
+// Start to End over Warmup iterations.
+type LinearKL struct {
+	Start  float64
+	End    float64
+	Delay  int
+	Warmup int
+}
+
+// KLAmount returns the annealed weight.
+func (l *LinearKL) KLAmount(iteration int) float64 {
+	if iteration < l.Delay {
+		return l.Start
+	} else if iteration >= l.Delay+l.Warmup {
+		return l.End
+	}
+	frac := float64(iteration-l.Delay) / float64(l.Warmup)
+	return l.Start + frac*(l.End-l.Start)
+}
+
+// SigmoidKL is a KLSchedule which anneals the weight from
+// 0 to Max along a logistic curve centered at Midpoint.
+//
+// Steepness determines how quickly the weight changes
+// around the midpoint.
+type SigmoidKL struct {
+	Max       float64
+	Midpoint  int
+	Steepness float64
+}
+
+// KLAmount returns the annealed weight.
+func (s *SigmoidKL) KLAmount(iteration int) float64 {
+	x := s.Steepness * float64(iteration-s.Midpoint)
+	return s.Max / (1 + math.Exp(-x))
+}
+
+// CyclicalKL is a KLSchedule which repeatedly anneals the
+// weight from 0 to Max.
+//
+// Each cycle lasts for Period iterations.
+// The weight increases linearly for the first
+// RampFraction of the cycle and stays at Max for the
+// rest of it.
+//
+// This is the schedule described in
+// https://arxiv.org/abs/1903.10145.
+type CyclicalKL struct {
+	Max          float64
+	Period       int
+	RampFraction float64
+}
+
+// KLAmount returns the annealed weight.
+func (c *CyclicalKL) KLAmount(iteration int) float64 {
+	frac := float64(iteration%c.Period) / float64(c.Period)
+	if frac >= c.RampFraction {
+		return c.Max
+	}
+	return c.Max * frac / c.RampFraction
+}
@@ -0,0 +1,171 @@
+package tweetenc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/serializer"
+)
+
+// ModelVersion is the format version of newly saved
+// Models.
+const ModelVersion = 1
+
+func init() {
+	var m Model
+	serializer.RegisterTypedDeserializer(m.SerializerType(), DeserializeModel)
+}
+
+// ModelMetadata stores information about how a Model was
+// created and trained.
+//
+// Fields which are unknown are zero.
+type ModelMetadata struct {
+	LatentSize int     `json:"latent_size"`
+	StateSize  int     `json:"state_size"`
+	KL         float64 `json:"kl"`
+	Dataset    string  `json:"dataset"`
+	Iteration  int     `json:"iteration"`
+
+	// BestValidation is the lowest validation cost seen
+	// during training, or 0 if the model was never
+	// validated.
+	// It lets training keep track of the best model across
+	// resumes.
+	BestValidation float64 `json:"best_validation,omitempty"`
+
+	// Architecture is the architecture the model was
+	// created with, if known.
+	Architecture *Architecture `json:"architecture,omitempty"`
+}
+
+// A Model bundles an Encoder and its corresponding
+// Decoder, along with metadata.
+type Model struct {
+	Encoder  *Encoder
+	Decoder  *Decoder
+	Metadata ModelMetadata
+}
+
+// DeserializeModel deserializes a Model.
+func DeserializeModel(d []byte) (*Model, error) {
+	var version int
+	var metadata []byte
+	var res Model
+	err := serializer.DeserializeAny(d, &version, &metadata, &res.Encoder, &res.Decoder)
+	if err != nil {
+		return nil, errors.New("deserialize Model: " + err.Error())
+	}
+	if version > ModelVersion {
+		return nil, fmt.Errorf("deserialize Model: unsupported version %d", version)
+	}
+	if err := json.Unmarshal(metadata, &res.Metadata); err != nil {
+		return nil, errors.New("deserialize Model: " + err.Error())
+	}
+	return &res, nil
+}
+
+// LoadModel loads a Model from a file.
+func LoadModel(path string) (*Model, error) {
+	var res *Model
+	if err := serializer.LoadAny(path, &res); err != nil {
+		return nil, errors.New("load model: " + err.Error())
+	}
+	return res, nil
+}
+
+// LoadLegacyModel loads a Model from separate encoder and
+// decoder files, which is how models used to be saved.
+//
+// The resulting Model has no metadata besides the latent
+// size.
+func LoadLegacyModel(encPath, decPath string) (*Model, error) {
+	res := &Model{}
+	if err := serializer.LoadAny(encPath, &res.Encoder); err != nil {
+		return nil, errors.New("load encoder: " + err.Error())
+	}
+	if err := serializer.LoadAny(decPath, &res.Decoder); err != nil {
+		return nil, errors.New("load decoder: " + err.Error())
+	}
+	latentSize, err := res.Check()
+	if err != nil {
+		return nil, err
+	}
+	res.Metadata.LatentSize = latentSize
+	return res, nil
+}
+
+// LoadModelFiles loads a Model from modelPath if that file
+// exists, or from the legacy encoder and decoder files
+// otherwise.
+func LoadModelFiles(modelPath, encPath, decPath string) (*Model, error) {
+	if _, err := os.Stat(modelPath); err == nil {
+		return LoadModel(modelPath)
+	}
+	return LoadLegacyModel(encPath, decPath)
+}
+
+// LoadEncoderFiles is like LoadModelFiles, except that it
+// only loads the encoder.
+func LoadEncoderFiles(modelPath, encPath string) (*Encoder, error) {
+	if _, err := os.Stat(modelPath); err == nil {
+		model, err := LoadModel(modelPath)
+		if err != nil {
+			return nil, err
+		}
+		return model.Encoder, nil
+	}
+	var res *Encoder
+	if err := serializer.LoadAny(encPath, &res); err != nil {
+		return nil, errors.New("load encoder: " + err.Error())
+	}
+	return res, nil
+}
+
+// Check makes sure that the Decoder can decode the
+// vectors produced by the Encoder, and that both use
+// vocabularies of the same size.
+//
+// On success, it returns the latent size.
+func (m *Model) Check() (int, error) {
+	if m.Encoder.vocab().Size() != m.Decoder.vocab().Size() {
+		return 0, fmt.Errorf("encoder has %d tokens but decoder has %d",
+			m.Encoder.vocab().Size(), m.Decoder.vocab().Size())
+	}
+	mean, _ := m.Encoder.Encode("a")
+	if inSize, ok := layerInSize(m.Decoder.StateMapper); ok && inSize != mean.Len() {
+		return 0, fmt.Errorf("encoder produces %d features but decoder expects %d",
+			mean.Len(), inSize)
+	}
+	return mean.Len(), nil
+}
+
+// SerializerType returns the unique ID used to serialize
+// a Model with the serializer package.
+func (m *Model) SerializerType() string {
+	return "github.com/unixpickle/tweetenc.Model"
+}
+
+// Serialize serializes the Model.
+func (m *Model) Serialize() ([]byte, error) {
+	metadata, err := json.Marshal(&m.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return serializer.SerializeAny(ModelVersion, metadata, m.Encoder, m.Decoder)
+}
+
+func layerInSize(l anynet.Layer) (int, bool) {
+	switch l := l.(type) {
+	case *anynet.FC:
+		return l.InCount, true
+	case anynet.Net:
+		if len(l) > 0 {
+			return layerInSize(l[0])
+		}
+	}
+	return 0, false
+}
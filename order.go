@@ -0,0 +1,72 @@
+package tweetenc
+
+import "math/rand"
+
+// An EpochOrder determines which batches are trained on
+// at each iteration of training.
+//
+// The samples are shuffled anew every epoch, but the
+// order of each epoch only depends on Seed and the epoch
+// number.
+// As a result, training which is resumed from any
+// iteration trains on the same batches as if it had
+// never been interrupted.
+type EpochOrder struct {
+	Samples   SampleList
+	BatchSize int
+
+	// Bucket, if true, groups samples of similar lengths
+	// into batches with NewBucketList.
+	// The samples are re-bucketed every epoch, so batches
+	// contain different samples each time.
+	Bucket bool
+
+	Seed int64
+
+	epoch   int
+	batches []SampleList
+}
+
+// NumBatches returns the number of batches per epoch.
+func (e *EpochOrder) NumBatches() int {
+	return (len(e.Samples) + e.BatchSize - 1) / e.BatchSize
+}
+
+// Epoch returns the batches of an epoch, in order.
+func (e *EpochOrder) Epoch(epoch int) []SampleList {
+	gen := rand.New(NewRandSource(e.Seed + int64(epoch)))
+	if e.Bucket {
+		res := NewBucketList(e.Samples, e.BatchSize, gen)
+		gen.Shuffle(len(res), func(i, j int) {
+			res[i], res[j] = res[j], res[i]
+		})
+		return res
+	}
+	shuffled := make(SampleList, len(e.Samples))
+	for i, j := range gen.Perm(len(e.Samples)) {
+		shuffled[i] = e.Samples[j]
+	}
+	var res []SampleList
+	for i := 0; i < len(shuffled); i += e.BatchSize {
+		end := i + e.BatchSize
+		if end > len(shuffled) {
+			end = len(shuffled)
+		}
+		res = append(res, shuffled[i:end])
+	}
+	return res
+}
+
+// Batch returns the batch for the given iteration, which
+// is counted from the start of the first epoch.
+//
+// The batches of the most recent epoch are cached, so
+// consecutive iterations are cheap.
+func (e *EpochOrder) Batch(iteration int) SampleList {
+	epoch := iteration / e.NumBatches()
+	if e.batches == nil || e.epoch != epoch {
+		e.epoch = epoch
+		e.batches = e.Epoch(epoch)
+	}
+	return e.batches[iteration%e.NumBatches()]
+}
@@ -0,0 +1,162 @@
+package tweetenc
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet/anysgd"
+	"github.com/unixpickle/anyvec"
+)
+
+// A ParallelTrainer is an anysgd.Fetcher and
+// anysgd.Gradienter which splits each batch into shards
+// and computes their gradients on separate goroutines.
+//
+// Each goroutine uses its own copy of Trainer, and all
+// of the copies share the same Encoder and Decoder.
+// The shards' gradients are weighted by their token
+// counts, so that their sum is the gradient Trainer would
+// compute for the whole batch.
+// Trainer's Last fields and Iteration are updated as if
+// Trainer had processed the whole batch itself.
+//
+// Random draws are made from Trainer.Rand in the same
+// order that Trainer would make them, so the results
+// match Trainer's up to rounding errors.
+//
+// FreeBits is not supported, since it applies to the
+// KL-divergence of the whole batch.
+type ParallelTrainer struct {
+	Trainer *Trainer
+
+	// NumWorkers is the maximum number of shards per
+	// batch.
+	// If 0, runtime.GOMAXPROCS(0) is used.
+	NumWorkers int
+}
+
+// Fetch splits the samples into shards and produces a
+// training batch for each shard.
+//
+// The list should be a SampleList or a BucketList.
+func (p *ParallelTrainer) Fetch(list anysgd.SampleList) (anysgd.Batch, error) {
+	if p.Trainer.FreeBits != 0 {
+		return nil, errors.New("free bits are not supported by ParallelTrainer")
+	}
+
+	var s SampleList
+	switch list := list.(type) {
+	case SampleList:
+		s = list
+	case BucketList:
+		s = list.Samples()
+	default:
+		return nil, errors.New("unsupported sample list type")
+	}
+
+	numShards := p.NumWorkers
+	if numShards == 0 {
+		numShards = runtime.GOMAXPROCS(0)
+	}
+	if numShards > len(s) {
+		numShards = len(s)
+	}
+
+	res := &parallelBatch{NumSamples: len(s)}
+	for i := 0; i < numShards; i++ {
+		shard := s[i*len(s)/numShards : (i+1)*len(s)/numShards]
+		worker := *p.Trainer
+		batch, err := worker.Fetch(shard)
+		if err != nil {
+			return nil, err
+		}
+		res.Workers = append(res.Workers, &worker)
+		res.Shards = append(res.Shards, batch)
+	}
+	return res, nil
+}
+
+// Gradient computes the gradient for the batch and
+// updates the Trainer's statistics.
+func (p *ParallelTrainer) Gradient(b anysgd.Batch) anydiff.Grad {
+	pb := b.(*parallelBatch)
+	c := p.Trainer.creator()
+
+	// The noise is drawn for the whole batch at once, since
+	// rand.Rand is not safe for concurrent use.
+	latentSize, ok := layerInSize(p.Trainer.Decoder.StateMapper)
+	if !ok {
+		panic("unknown latent size")
+	}
+	noise := c.MakeVector(latentSize * pb.NumSamples)
+	anyvec.Rand(noise, anyvec.Normal, p.Trainer.Rand)
+	var offset int
+	for _, shard := range pb.Shards {
+		tb := shard.(*trainerBatch)
+		size := latentSize * len(tb.Desired.Output()[0].Present)
+		tb.Noise = noise.Slice(offset, offset+size)
+		offset += size
+	}
+
+	grads := make([]anydiff.Grad, len(pb.Shards))
+	terms := make([]*costTerms, len(pb.Shards))
+
+	var wg sync.WaitGroup
+	for i := range pb.Shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			worker := pb.Workers[i]
+			worker.Iteration = p.Trainer.Iteration
+			grads[i], _, terms[i] = worker.gradient(pb.Shards[i])
+		}(i)
+	}
+	wg.Wait()
+
+	merged := mergeCostTerms(terms)
+	res := grads[0]
+	for i, grad := range grads {
+		scale := c.MakeNumeric(float64(terms[i].NumTokens) / float64(merged.NumTokens))
+		for param, vec := range grad {
+			vec.Scale(scale)
+			if i > 0 {
+				res[param].Add(vec)
+			}
+		}
+	}
+
+	cost := (merged.Reconstruction + merged.WeightedKL) / float64(merged.NumTokens)
+	p.Trainer.setLast(c.MakeNumeric(cost), merged)
+	p.Trainer.Iteration++
+	return res
+}
+
+type parallelBatch struct {
+	Workers    []*Trainer
+	Shards     []anysgd.Batch
+	NumSamples int
+}
+
+// mergeCostTerms combines the costTerms of multiple
+// shards into the costTerms for the entire batch.
+func mergeCostTerms(terms []*costTerms) *costTerms {
+	res := &costTerms{}
+	for _, t := range terms {
+		res.NumSamples += t.NumSamples
+		res.NumTokens += t.NumTokens
+		res.NumBytes += t.NumBytes
+		res.Reconstruction += t.Reconstruction
+		res.KL += t.KL
+		res.WeightedKL += t.WeightedKL
+		res.Means = append(res.Means, t.Means...)
+	}
+	res.GroupKL = make([]float64, len(terms[0].GroupKL))
+	for _, t := range terms {
+		for i, x := range t.GroupKL {
+			res.GroupKL[i] += x * float64(t.NumSamples) / float64(res.NumSamples)
+		}
+	}
+	return res
+}
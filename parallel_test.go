@@ -0,0 +1,61 @@
+package tweetenc
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestParallelTrainerGradient(t *testing.T) {
+	trainer := testTrainer()
+	trainer.KL = 0.5
+	trainer.GuideDropout = 0.2
+	trainer.Corruption = &Corruption{Substitute: 0.1, Swap: 0.1}
+
+	parallelTrainer := *trainer
+	trainer.Rand = rand.New(NewRandSource(1337))
+	parallelTrainer.Rand = rand.New(NewRandSource(1337))
+	parallel := &ParallelTrainer{Trainer: &parallelTrainer, NumWorkers: 3}
+
+	samples := testSamples()
+	batch, err := trainer.Fetch(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := trainer.Gradient(batch)
+	parallelBatch, err := parallel.Fetch(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := parallel.Gradient(parallelBatch)
+
+	expectedCost := numericFloat(trainer.LastCost)
+	actualCost := numericFloat(parallelTrainer.LastCost)
+	if math.Abs(expectedCost-actualCost) > 1e-8 {
+		t.Errorf("expected cost %f but got %f", expectedCost, actualCost)
+	}
+	if parallelTrainer.Iteration != trainer.Iteration {
+		t.Errorf("expected iteration %d but got %d", trainer.Iteration,
+			parallelTrainer.Iteration)
+	}
+	for i, param := range trainer.Parameters() {
+		expectedData := vectorData(expected[param])
+		actualData := vectorData(actual[param])
+		for j, x := range expectedData {
+			if math.Abs(x-actualData[j]) > 1e-8 {
+				t.Errorf("parameter %d: entry %d should be %f but got %f", i, j, x,
+					actualData[j])
+				break
+			}
+		}
+	}
+}
+
+func TestParallelTrainerFreeBits(t *testing.T) {
+	trainer := testTrainer()
+	trainer.FreeBits = 0.1
+	parallel := &ParallelTrainer{Trainer: trainer, NumWorkers: 2}
+	if _, err := parallel.Fetch(testSamples()); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,267 @@
+package tweetenc
+
+import (
+	"errors"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anydiff/anyseq"
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/serializer"
+)
+
+func init() {
+	var m MeanPool
+	serializer.RegisterTypedDeserializer(m.SerializerType(), DeserializeMeanPool)
+	var x MaxPool
+	serializer.RegisterTypedDeserializer(x.SerializerType(), DeserializeMaxPool)
+	var a AttentionPool
+	serializer.RegisterTypedDeserializer(a.SerializerType(), DeserializeAttentionPool)
+}
+
+// A Pooler summarizes the outputs of an Encoder's
+// recurrent block with one vector per sequence.
+type Pooler interface {
+	serializer.Serializer
+
+	// Pool produces a matrix with one row per sequence.
+	//
+	// Every sequence must be present at the first
+	// timestep.
+	Pool(s anyseq.Seq) anydiff.Res
+}
+
+// MeanPool is a Pooler which averages the outputs from
+// every timestep.
+type MeanPool struct{}
+
+// DeserializeMeanPool deserializes a MeanPool.
+func DeserializeMeanPool(d []byte) (MeanPool, error) {
+	return MeanPool{}, nil
+}
+
+// Pool averages the timesteps of each sequence.
+func (m MeanPool) Pool(s anyseq.Seq) anydiff.Res {
+	lengths := seqLengths(s)
+	cols := s.Output()[0].Packed.Len() / len(lengths)
+	scales := make([]float64, 0, len(lengths)*cols)
+	for _, length := range lengths {
+		for j := 0; j < cols; j++ {
+			scales = append(scales, 1/float64(length))
+		}
+	}
+	c := s.Output()[0].Packed.Creator()
+	return anydiff.Mul(anyseq.Sum(s), anydiff.NewConst(makeVector(c, scales)))
+}
+
+// SerializerType returns the unique ID used to serialize
+// a MeanPool with the serializer package.
+func (m MeanPool) SerializerType() string {
+	return "github.com/unixpickle/tweetenc.MeanPool"
+}
+
+// Serialize serializes the MeanPool.
+func (m MeanPool) Serialize() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// MaxPool is a Pooler which takes the maximum of each
+// output component over all timesteps.
+type MaxPool struct{}
+
+// DeserializeMaxPool deserializes a MaxPool.
+func DeserializeMaxPool(d []byte) (MaxPool, error) {
+	return MaxPool{}, nil
+}
+
+// Pool max-pools the timesteps of each sequence.
+//
+// Gradients only flow to the timestep which produced
+// each maximum.
+func (m MaxPool) Pool(s anyseq.Seq) anydiff.Res {
+	batches := s.Output()
+	numSeqs := len(batches[0].Present)
+	cols := batches[0].Packed.Len() / numSeqs
+
+	maxes := make([]float64, numSeqs*cols)
+	argMaxes := make([]int, numSeqs*cols)
+	for t, batch := range batches {
+		data := vectorData(batch.Packed)
+		for row, seqIdx := range presentIndices(batch) {
+			for j := 0; j < cols; j++ {
+				val := data[row*cols+j]
+				idx := seqIdx*cols + j
+				if t == 0 || val > maxes[idx] {
+					maxes[idx] = val
+					argMaxes[idx] = t
+				}
+			}
+		}
+	}
+
+	c := batches[0].Packed.Creator()
+	var t int
+	masked := anyseq.Map(s, func(v anydiff.Res, n int) anydiff.Res {
+		mask := make([]float64, 0, n*cols)
+		for _, seqIdx := range presentIndices(batches[t]) {
+			for j := 0; j < cols; j++ {
+				if argMaxes[seqIdx*cols+j] == t {
+					mask = append(mask, 1)
+				} else {
+					mask = append(mask, 0)
+				}
+			}
+		}
+		t++
+		return anydiff.Mul(v, anydiff.NewConst(makeVector(c, mask)))
+	})
+	return anyseq.Sum(masked)
+}
+
+// SerializerType returns the unique ID used to serialize
+// a MaxPool with the serializer package.
+func (m MaxPool) SerializerType() string {
+	return "github.com/unixpickle/tweetenc.MaxPool"
+}
+
+// Serialize serializes the MaxPool.
+func (m MaxPool) Serialize() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// AttentionPool is a Pooler which computes a weighted
+// average of the outputs from every timestep.
+//
+// The weights are a softmax over the scores which Scorer
+// assigns to the timesteps of a sequence.
+type AttentionPool struct {
+	// Scorer maps each output vector to a scalar.
+	Scorer anynet.Layer
+}
+
+// DeserializeAttentionPool deserializes an AttentionPool.
+func DeserializeAttentionPool(d []byte) (*AttentionPool, error) {
+	var scorer anynet.Layer
+	if err := serializer.DeserializeAny(d, &scorer); err != nil {
+		return nil, errors.New("deserialize AttentionPool: " + err.Error())
+	}
+	return &AttentionPool{Scorer: scorer}, nil
+}
+
+// Pool computes the attention-weighted average of the
+// timesteps of each sequence.
+func (a *AttentionPool) Pool(s anyseq.Seq) anydiff.Res {
+	batches := s.Output()
+	numSeqs := len(batches[0].Present)
+	cols := batches[0].Packed.Len() / numSeqs
+
+	// Softmax is invariant to shifting the scores, so the
+	// maximum scores can be treated as constants.
+	maxScores := make([]float64, numSeqs)
+	for t, batch := range anyseq.Map(s, a.Scorer.Apply).Output() {
+		data := vectorData(batch.Packed)
+		for row, seqIdx := range presentIndices(batch) {
+			score := data[row]
+			if t == 0 || score > maxScores[seqIdx] {
+				maxScores[seqIdx] = score
+			}
+		}
+	}
+
+	// Each timestep produces rows of the form [w*h, w],
+	// so that one sum yields both the numerators and the
+	// denominators of the weighted averages.
+	c := batches[0].Packed.Creator()
+	var t int
+	weighted := anyseq.Map(s, func(v anydiff.Res, n int) anydiff.Res {
+		offsets := make([]float64, 0, n)
+		for _, seqIdx := range presentIndices(batches[t]) {
+			offsets = append(offsets, -maxScores[seqIdx])
+		}
+		t++
+		scores := anydiff.Add(a.Scorer.Apply(v, n), anydiff.NewConst(makeVector(c, offsets)))
+		return anydiff.Pool(anydiff.Exp(scores), func(weights anydiff.Res) anydiff.Res {
+			return concatRows(anydiff.Mul(v, repeatCols(weights, n, cols)), weights, n)
+		})
+	})
+
+	return anydiff.Pool(anyseq.Sum(weighted), func(sums anydiff.Res) anydiff.Res {
+		total := selectCols(sums, numSeqs, cols, cols+1)
+		norm := anydiff.Pow(total, c.MakeNumeric(-1))
+		return anydiff.Mul(selectCols(sums, numSeqs, 0, cols), repeatCols(norm, numSeqs, cols))
+	})
+}
+
+// Parameters returns the parameters of the Scorer.
+func (a *AttentionPool) Parameters() []*anydiff.Var {
+	if p, ok := a.Scorer.(anynet.Parameterizer); ok {
+		return p.Parameters()
+	}
+	return nil
+}
+
+// SerializerType returns the unique ID used to serialize
+// an AttentionPool with the serializer package.
+func (a *AttentionPool) SerializerType() string {
+	return "github.com/unixpickle/tweetenc.AttentionPool"
+}
+
+// Serialize serializes the AttentionPool.
+func (a *AttentionPool) Serialize() ([]byte, error) {
+	return serializer.SerializeAny(a.Scorer)
+}
+
+// seqLengths computes the length of every sequence in a
+// batch.
+func seqLengths(s anyseq.Seq) []int {
+	res := make([]int, len(s.Output()[0].Present))
+	for _, batch := range s.Output() {
+		for _, seqIdx := range presentIndices(batch) {
+			res[seqIdx]++
+		}
+	}
+	return res
+}
+
+// presentIndices returns the index of the sequence
+// corresponding to each packed row of a batch.
+func presentIndices(b *anyseq.Batch) []int {
+	var res []int
+	for i, present := range b.Present {
+		if present {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// repeatCols turns a vector into a matrix with cols
+// copies of the vector as its columns.
+func repeatCols(v anydiff.Res, rows, cols int) anydiff.Res {
+	table := make([]int, 0, rows*cols)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			table = append(table, i)
+		}
+	}
+	mapper := v.Output().Creator().MakeMapper(rows, table)
+	return anydiff.Map(mapper, v)
+}
+
+// selectCols extracts the columns in the range
+// [start, end) from a matrix.
+func selectCols(m anydiff.Res, rows, start, end int) anydiff.Res {
+	cols := m.Output().Len() / rows
+	table := make([]int, 0, rows*(end-start))
+	for i := 0; i < rows; i++ {
+		for j := start; j < end; j++ {
+			table = append(table, i*cols+j)
+		}
+	}
+	mapper := m.Output().Creator().MakeMapper(m.Output().Len(), table)
+	return anydiff.Map(mapper, m)
+}
+
+func makeVector(c anyvec.Creator, data []float64) anyvec.Vector {
+	return c.MakeVectorData(c.MakeNumericList(data))
+}
@@ -0,0 +1,34 @@
+package tweetenc
+
+// A RandSource is a math/rand.Source whose entire state
+// is a single exported integer, making it easy to save
+// and restore.
+//
+// It implements the SplitMix64 generator.
+type RandSource struct {
+	State uint64
+}
+
+// NewRandSource creates a RandSource with the given seed.
+func NewRandSource(seed int64) *RandSource {
+	return &RandSource{State: uint64(seed)}
+}
+
+// Seed resets the state of the source.
+func (r *RandSource) Seed(seed int64) {
+	r.State = uint64(seed)
+}
+
+// Uint64 generates a random 64-bit integer.
+func (r *RandSource) Uint64() uint64 {
+	r.State += 0x9e3779b97f4a7c15
+	z := r.State
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Int63 generates a random non-negative 63-bit integer.
+func (r *RandSource) Int63() int64 {
+	return int64(r.Uint64() >> 1)
+}
@@ -0,0 +1,66 @@
+package tweetenc
+
+import (
+	"math"
+
+	"github.com/unixpickle/anynet/anysgd"
+)
+
+// StepDecayRater is an anysgd.Rater which multiplies the
+// step size by Factor every Interval epochs.
+type StepDecayRater struct {
+	Base     float64
+	Factor   float64
+	Interval float64
+}
+
+// Rate returns the decayed step size.
+func (s *StepDecayRater) Rate(epoch float64) float64 {
+	return s.Base * math.Pow(s.Factor, math.Floor(epoch/s.Interval))
+}
+
+// ExpDecayRater is an anysgd.Rater which smoothly
+// multiplies the step size by Factor every epoch.
+type ExpDecayRater struct {
+	Base   float64
+	Factor float64
+}
+
+// Rate returns the decayed step size.
+func (e *ExpDecayRater) Rate(epoch float64) float64 {
+	return e.Base * math.Pow(e.Factor, epoch)
+}
+
+// CosineRater is an anysgd.Rater which anneals the step
+// size from Base to Min over Epochs epochs, following
+// half a cosine wave.
+//
+// After Epochs epochs, the step size stays at Min.
+type CosineRater struct {
+	Base   float64
+	Min    float64
+	Epochs float64
+}
+
+// Rate returns the annealed step size.
+func (c *CosineRater) Rate(epoch float64) float64 {
+	frac := math.Min(epoch/c.Epochs, 1)
+	return c.Min + 0.5*(c.Base-c.Min)*(1+math.Cos(math.Pi*frac))
+}
+
+// WarmupRater is an anysgd.Rater which linearly increases
+// the step size from 0 for the first Warmup epochs.
+// The step size is ultimately determined by Rater.
+type WarmupRater struct {
+	Rater  anysgd.Rater
+	Warmup float64
+}
+
+// Rate returns the step size with warm-up applied.
+func (w *WarmupRater) Rate(epoch float64) float64 {
+	rate := w.Rater.Rate(epoch)
+	if epoch < w.Warmup {
+		rate *= epoch / w.Warmup
+	}
+	return rate
+}
@@ -8,13 +8,15 @@ package main
 import (
 	"flag"
 	"fmt"
+	"math/rand"
 	"os"
+	"time"
 
-	"github.com/unixpickle/serializer"
 	"github.com/unixpickle/tweetenc"
 )
 
 func main() {
+	var modelFile string
 	var encFile string
 	var decFile string
 	var startStr string
@@ -22,11 +24,26 @@ func main() {
 	var numStops int
 	var endStr string
 
-	flag.StringVar(&encFile, "encoder", "../train/enc_out", "encoder input file")
-	flag.StringVar(&decFile, "decoder", "../train/dec_out", "decoder input file")
+	var beamSize int
+	var maxLen int
+
+	var numSamples int
+	var sampleOpts tweetenc.SampleOptions
+	var seed int64
+
+	flag.StringVar(&modelFile, "model", "../train/model_out", "model input file")
+	flag.StringVar(&encFile, "encoder", "../train/enc_out", "legacy encoder input file")
+	flag.StringVar(&decFile, "decoder", "../train/dec_out", "legacy decoder input file")
 	flag.StringVar(&startStr, "tweet", "", "tweet body")
 	flag.IntVar(&numStops, "stops", 1, "interpolation stops")
 	flag.StringVar(&endStr, "end", "", "end tweet body for interpolation")
+	flag.IntVar(&beamSize, "beam", 0, "beam search width (0 for greedy decoding)")
+	flag.IntVar(&maxLen, "maxlen", 280, "maximum length of decoded outputs")
+	flag.IntVar(&numSamples, "samples", 0, "number of stochastic decodings to draw")
+	flag.Float64Var(&sampleOpts.Temperature, "temp", 1, "sampling temperature")
+	flag.IntVar(&sampleOpts.TopK, "topk", 0, "sample from the top k bytes (0 for all)")
+	flag.Float64Var(&sampleOpts.TopP, "topp", 0, "nucleus sampling probability (0 to disable)")
+	flag.Int64Var(&seed, "seed", 0, "random seed for sampling (0 to seed from the time)")
 
 	flag.Parse()
 
@@ -40,28 +57,40 @@ func main() {
 		os.Exit(1)
 	}
 
-	enc := &tweetenc.Encoder{}
-	if err := serializer.LoadAny(encFile, &enc); err != nil {
-		fmt.Fprintln(os.Stderr, "load encoder:", err)
-		os.Exit(1)
-	}
-
-	dec := &tweetenc.Decoder{}
-	if err := serializer.LoadAny(decFile, &dec); err != nil {
-		fmt.Fprintln(os.Stderr, "load decoder:", err)
+	model, err := tweetenc.LoadModelFiles(modelFile, encFile, decFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	enc, dec := model.Encoder, model.Decoder
 
 	if numStops != 1 {
-		interpolate(startStr, endStr, enc, dec, numStops)
+		interpolate(startStr, endStr, enc, dec, numStops, maxLen)
+	} else if numSamples > 0 {
+		encoded, _ := enc.Encode(startStr)
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		gen := rand.New(tweetenc.NewRandSource(seed))
+		fmt.Println("Samples:")
+		for i := 0; i < numSamples; i++ {
+			fmt.Println(string(dec.Sample(encoded, maxLen, &sampleOpts, gen)))
+		}
+	} else if beamSize > 0 {
+		encoded, _ := enc.Encode(startStr)
+		fmt.Println("Beam search results:")
+		for _, cand := range dec.Beam(encoded, beamSize, maxLen) {
+			fmt.Printf("%.3f: %s\n", cand.LogProb, string(cand.Bytes))
+		}
 	} else {
 		encoded, _ := enc.Encode(startStr)
-		decoded := dec.Unguided(encoded)
+		decoded := dec.Unguided(encoded, maxLen)
 		fmt.Println("Decoded to:", string(decoded))
 	}
 }
 
-func interpolate(start, end string, enc *tweetenc.Encoder, dec *tweetenc.Decoder, stops int) {
+func interpolate(start, end string, enc *tweetenc.Encoder, dec *tweetenc.Decoder,
+	stops, maxLen int) {
 	startVec, _ := enc.Encode(start)
 	endVec, _ := enc.Encode(end)
 
@@ -73,6 +102,6 @@ func interpolate(start, end string, enc *tweetenc.Encoder, dec *tweetenc.Decoder
 		vec2.Scale(vec2.Creator().MakeNumeric(fracDone))
 		vec1.Add(vec2)
 
-		fmt.Printf("%.3f: %s\n", fracDone, string(dec.Unguided(vec1)))
+		fmt.Printf("%.3f: %s\n", fracDone, string(dec.Unguided(vec1, maxLen)))
 	}
 }
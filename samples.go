@@ -2,6 +2,7 @@ package tweetenc
 
 import (
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/csv"
 	"errors"
 	"os"
@@ -60,3 +61,27 @@ func (s SampleList) Hash(i int) []byte {
 	res := md5.Sum(s[i])
 	return res[:]
 }
+
+// Split partitions the samples into training, validation,
+// and testing sets.
+//
+// The partition is determined by the samples' hashes, so
+// a given sample always lands in the same set, regardless
+// of the order or contents of the rest of the list.
+//
+// The valFrac and testFrac arguments specify the expected
+// fraction of samples in the validation and testing sets.
+func (s SampleList) Split(valFrac, testFrac float64) (train, val, test SampleList) {
+	for i, sample := range s {
+		hash := s.Hash(i)
+		frac := float64(binary.BigEndian.Uint64(hash[:8])) / (1 << 64)
+		if frac < valFrac {
+			val = append(val, sample)
+		} else if frac < valFrac+testFrac {
+			test = append(test, sample)
+		} else {
+			train = append(train, sample)
+		}
+	}
+	return
+}
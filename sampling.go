@@ -0,0 +1,123 @@
+package tweetenc
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anyvec"
+)
+
+// SampleOptions controls how Decoder.Sample draws tokens
+// from the decoder's output distribution.
+type SampleOptions struct {
+	// Temperature divides the log-probabilities before
+	// they are normalized.
+	// Values below 1 make the output more conservative,
+	// while values above 1 make it more diverse.
+	//
+	// If this is 0, a temperature of 1 is used.
+	Temperature float64
+
+	// TopK, if non-zero, restricts sampling to the TopK
+	// most likely tokens at every timestep.
+	TopK int
+
+	// TopP, if non-zero, restricts sampling to the smallest
+	// set of tokens whose total probability is at least
+	// TopP (nucleus sampling).
+	TopP float64
+}
+
+// Sample stochastically decodes a feature vector by
+// drawing each token from the decoder's distribution.
+//
+// If opts is nil, bytes are drawn from the unmodified
+// distribution.
+// If gen is nil, the global math/rand source is used.
+//
+// Outputs are cut off after maxLen tokens.
+func (d *Decoder) Sample(encoded anyvec.Vector, maxLen int, opts *SampleOptions,
+	gen *rand.Rand) []byte {
+	if opts == nil {
+		opts = &SampleOptions{}
+	}
+	vocab := d.vocab()
+	mapped := d.StateMapper.Apply(anydiff.NewConst(encoded), 1)
+	state := d.vecToState(mapped.Output(), 1)
+	input := oneHot(encoded.Creator(), vocab.Size(), 0)
+	var tokens []int
+	for len(tokens) < maxLen {
+		next := d.Block.Step(state, input)
+		state = next.State()
+
+		token := opts.sample(vectorData(next.Output()), gen)
+		if token == 0 {
+			break
+		}
+		tokens = append(tokens, token)
+		input = oneHot(encoded.Creator(), vocab.Size(), token)
+	}
+	return vocab.Detokenize(tokens)
+}
+
+// sample draws an index from a vector of
+// log-probabilities, applying the options.
+func (s *SampleOptions) sample(logProbs []float64, gen *rand.Rand) int {
+	temp := s.Temperature
+	if temp == 0 {
+		temp = 1
+	}
+
+	indices := make([]int, len(logProbs))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return logProbs[indices[i]] > logProbs[indices[j]]
+	})
+	if s.TopK > 0 && s.TopK < len(indices) {
+		indices = indices[:s.TopK]
+	}
+
+	// Normalize with the max subtracted for stability.
+	maxVal := logProbs[indices[0]] / temp
+	probs := make([]float64, len(indices))
+	var total float64
+	for i, idx := range indices {
+		probs[i] = math.Exp(logProbs[idx]/temp - maxVal)
+		total += probs[i]
+	}
+	for i := range probs {
+		probs[i] /= total
+	}
+	total = 1
+
+	if s.TopP > 0 {
+		var cumulative float64
+		for i, p := range probs {
+			cumulative += p
+			if cumulative >= s.TopP {
+				indices = indices[:i+1]
+				probs = probs[:i+1]
+				total = cumulative
+				break
+			}
+		}
+	}
+
+	var x float64
+	if gen == nil {
+		x = rand.Float64() * total
+	} else {
+		x = gen.Float64() * total
+	}
+	for i, p := range probs {
+		x -= p
+		if x < 0 {
+			return indices[i]
+		}
+	}
+	return indices[len(indices)-1]
+}
@@ -2,6 +2,8 @@ package tweetenc
 
 import (
 	"errors"
+	"math"
+	"math/rand"
 
 	"github.com/unixpickle/anydiff"
 	"github.com/unixpickle/anydiff/anyseq"
@@ -20,57 +22,200 @@ type Trainer struct {
 	// has on the cost.
 	// As this increases towards 1, the auto-encoder becomes
 	// more and more of a VAE.
+	//
+	// KL is ignored if KLSchedule is non-nil.
 	KL float64
 
+	// KLSchedule, if non-nil, determines the KL weight at
+	// each iteration.
+	KLSchedule KLSchedule
+
+	// FreeBits, if non-zero, is the minimum KL penalty (in
+	// nats) for each group of latent dimensions.
+	//
+	// The KL-divergence of each group is averaged over the
+	// batch, and groups with less KL-divergence than
+	// FreeBits are penalized as if they had exactly
+	// FreeBits of KL-divergence.
+	// This way, the encoder is not rewarded for squeezing
+	// the information out of a group entirely.
+	FreeBits float64
+
+	// FreeBitsGroup is the number of latent dimensions in
+	// each free-bits group.
+	// It must divide the latent size.
+	//
+	// If this is 0 or 1, each dimension is its own group.
+	FreeBitsGroup int
+
+	// GuideDropout is the probability of replacing each
+	// token of the decoder's guide sequence with an unknown
+	// input during training.
+	//
+	// This is the word dropout technique described in
+	// https://arxiv.org/abs/1511.06349, and it forces the
+	// decoder to rely more on the encoded vector.
+	GuideDropout float64
+
+	// Corruption, if non-nil, is applied to the encoder's
+	// inputs during training, while the decoder is still
+	// trained to produce the original samples.
+	// This makes the Trainer train a denoising
+	// auto-encoder.
+	Corruption *Corruption
+
+	// Frozen lists parts of the Encoder or Decoder, such as
+	// Encoder.MeanEncoder or the whole Decoder, which should
+	// not be trained.
+	// Entries which are not anynet.Parameterizers are
+	// ignored.
+	Frozen []interface{}
+
+	// Rand, if non-nil, is the source of the Trainer's
+	// randomness, such as the noise for the
+	// reparameterization trick, GuideDropout, and
+	// Corruption.
+	// If nil, the global math/rand source is used.
+	Rand *rand.Rand
+
 	// LastCost is set every time Gradient is called.
 	LastCost anyvec.Numeric
 
+	// LastKLWeight is set every time Gradient is called to
+	// the KL weight used for the batch.
+	LastKLWeight float64
+
+	// LastReconstruction is set every time Gradient is
+	// called to the average reconstruction cross-entropy
+	// of a sample, in nats.
+	LastReconstruction float64
+
+	// LastKL is set every time Gradient is called to the
+	// average KL-divergence of a sample, in nats.
+	// It is not affected by the KL weight or free bits.
+	LastKL float64
+
+	// LastWeightedKL is set every time Gradient is called
+	// to the average KL penalty of a sample, in nats.
+	// Unlike LastKL, it includes the effects of the KL
+	// weight and free bits.
+	LastWeightedKL float64
+
+	// LastBitsPerByte is set every time Gradient is called
+	// to the reconstruction cross-entropy of a byte, in
+	// bits.
+	LastBitsPerByte float64
+
+	// LastActiveUnits is set every time Gradient is called
+	// to the number of latent dimensions whose means vary
+	// significantly across the batch.
+	// Dimensions which are not active carry almost no
+	// information about the samples.
+	LastActiveUnits int
+
+	// LastGroupKL is set every time Gradient is called.
+	// It stores the KL-divergence in effect for each
+	// free-bits group, averaged over the batch.
+	// If each group is a single dimension, this is the
+	// KL-divergence of each dimension.
+	LastGroupKL []float64
+
 	// Iteration is incremented for every Gradient call and
 	// is passed to KLAmount to compute the rate.
 	Iteration int
 }
 
+// KLAmount returns the current KL weight.
+func (t *Trainer) KLAmount() float64 {
+	if t.KLSchedule != nil {
+		return t.KLSchedule.KLAmount(t.Iteration)
+	}
+	return t.KL
+}
+
 // Fetch produces a batch that represents the training
-// samples in the SampleList.
-func (t *Trainer) Fetch(s anysgd.SampleList) (anysgd.Batch, error) {
+// samples in the list, which should be a SampleList or a
+// BucketList.
+//
+// The batch is meant for training, so it is subject to
+// training-time perturbations like GuideDropout and
+// Corruption.
+func (t *Trainer) Fetch(list anysgd.SampleList) (anysgd.Batch, error) {
+	return t.fetch(list, true)
+}
+
+func (t *Trainer) fetch(list anysgd.SampleList, training bool) (anysgd.Batch, error) {
 	cr := t.creator()
-	zero := oneHot(cr, 0)
 
-	inSeqs := make([][]anyvec.Vector, s.Len())
-	guideSeqs := make([][]anyvec.Vector, s.Len())
-	for i := range inSeqs {
-		data := s.(SampleList)[i]
+	var s SampleList
+	switch list := list.(type) {
+	case SampleList:
+		s = list
+	case BucketList:
+		s = list.Samples()
+	default:
+		return nil, errors.New("unsupported sample list type")
+	}
+
+	for _, data := range s {
 		if len(data) == 0 {
 			return nil, errors.New("encountered empty sample string")
 		}
-		seq := []anyvec.Vector{zero}
-		for _, x := range data {
-			seq = append(seq, oneHot(cr, x))
-		}
-		seq = append(seq, zero)
-		inSeqs[i] = seq[1:]
-		guideSeqs[i] = seq[:len(seq)-1]
 	}
+	inSeqs, guideSeqs := teacherForcedSeqs(cr, t.Decoder.vocab(), s)
 
+	// Random draws are made one sample at a time, so that
+	// fetching a batch in several pieces draws the same
+	// numbers as fetching it all at once.
+	inVocab := t.Encoder.vocab()
+	var unknown anyvec.Vector
+	if training && t.GuideDropout != 0 {
+		unknown = cr.MakeVector(t.Decoder.vocab().Size())
+	}
 	revIn := make([][]anyvec.Vector, s.Len())
-	for i, seq := range guideSeqs {
+	var numBytes int
+	for i, sample := range s {
+		numBytes += len(sample) + 1
+		if training && t.Corruption != nil {
+			sample = t.Corruption.Apply(sample, t.Rand)
+		}
+		tokens := inVocab.Tokenize(sample)
 		var rev []anyvec.Vector
-		// Skip index 0 since that's a null-terminator.
-		for j := len(seq) - 1; j > 0; j-- {
-			rev = append(rev, seq[j])
+		for j := len(tokens) - 1; j >= 0; j-- {
+			rev = append(rev, oneHot(cr, inVocab.Size(), tokens[j]))
 		}
 		revIn[i] = rev
+
+		if training && t.GuideDropout != 0 {
+			// The guide shares its backing array with the
+			// desired outputs, so it must be copied.
+			seq := append([]anyvec.Vector{}, guideSeqs[i]...)
+			// Never drop the initial null-terminator.
+			for j := 1; j < len(seq); j++ {
+				if genFloat(t.Rand) < t.GuideDropout {
+					seq[j] = unknown
+				}
+			}
+			guideSeqs[i] = seq
+		}
 	}
 
 	return &trainerBatch{
 		ReversedIn: anyseq.ConstSeqList(cr, revIn),
 		Desired:    anyseq.ConstSeqList(cr, inSeqs),
 		Guide:      anyseq.ConstSeqList(cr, guideSeqs),
+		NumBytes:   numBytes,
 	}, nil
 }
 
 // TotalCost computes the average cost for the batch.
 func (t *Trainer) TotalCost(b anysgd.Batch) anydiff.Res {
+	cost, _ := t.totalCost(b)
+	return cost
+}
+
+func (t *Trainer) totalCost(b anysgd.Batch) (anydiff.Res, *costTerms) {
+	terms := &costTerms{}
 	tb := b.(*trainerBatch)
 	batchSize := len(tb.Desired.Output()[0].Present)
 	multiEnc := t.Encoder.Apply(tb.ReversedIn)
@@ -81,9 +226,15 @@ func (t *Trainer) TotalCost(b anysgd.Batch) anydiff.Res {
 		c := mean.Output().Creator()
 
 		stddev := anydiff.Exp(logStddev)
-		noise := c.MakeVector(mean.Output().Len())
-		anyvec.Rand(noise, anyvec.Normal, nil)
-		sampled := anydiff.Add(mean, anydiff.Mul(anydiff.NewConst(noise), stddev))
+		sampled := mean
+		if !tb.UseMean {
+			noise := tb.Noise
+			if noise == nil {
+				noise = c.MakeVector(mean.Output().Len())
+				anyvec.Rand(noise, anyvec.Normal, t.Rand)
+			}
+			sampled = anydiff.Add(mean, anydiff.Mul(anydiff.NewConst(noise), stddev))
+		}
 
 		decoded := t.Decoder.Guided(sampled, tb.Guide, batchSize)
 
@@ -99,55 +250,277 @@ func (t *Trainer) TotalCost(b anysgd.Batch) anydiff.Res {
 
 		sum := anydiff.Sum(anyseq.Sum(allCosts))
 
-		variances := anydiff.Square(stddev)
-		klDivergence := anydiff.AddScalar(
-			anydiff.Add(anydiff.Sum(variances), anydiff.Dot(mean, mean)),
-			c.MakeNumeric(float64(-mean.Output().Len())),
-		)
-		klDivergence = anydiff.Scale(klDivergence, c.MakeNumeric(0.5))
-		klDivergence = anydiff.Sub(klDivergence, anydiff.Sum(logStddev))
-		klDivergence = anydiff.Scale(klDivergence, c.MakeNumeric(t.KL))
+		groupKL, rawKL := t.groupKL(mean, logStddev, stddev, batchSize)
+		klDivergence := anydiff.Sum(groupKL)
+		klDivergence = anydiff.Scale(klDivergence, c.MakeNumeric(t.KLAmount()))
+
+		terms.NumSamples = batchSize
+		terms.NumTokens = costCount
+		terms.NumBytes = tb.NumBytes
+		terms.Reconstruction = vectorData(sum.Output())[0]
+		terms.KL = rawKL
+		terms.WeightedKL = vectorData(klDivergence.Output())[0]
+		terms.Means = vectorData(mean.Output())
+		terms.GroupKL = vectorData(groupKL.Output())
+		for i := range terms.GroupKL {
+			terms.GroupKL[i] /= float64(batchSize)
+		}
 
 		scaler := c.MakeNumeric(1 / float64(costCount))
 		return anydiff.Fuse(anydiff.Scale(anydiff.Add(sum, klDivergence), scaler))
 	})
 	return anydiff.Unfuse(res, func(reses []anydiff.Res) anydiff.Res {
 		return reses[0]
+	}), terms
+}
+
+// groupKL computes the KL-divergence of each free-bits
+// group, summed across the batch, with free bits taken
+// into account.
+//
+// It also returns the total KL-divergence of the batch
+// without free bits.
+func (t *Trainer) groupKL(mean, logStddev, stddev anydiff.Res,
+	batchSize int) (anydiff.Res, float64) {
+	c := mean.Output().Creator()
+	latentSize := mean.Output().Len() / batchSize
+
+	elemKL := anydiff.Add(anydiff.Square(stddev), anydiff.Square(mean))
+	elemKL = anydiff.AddScalar(elemKL, c.MakeNumeric(-1))
+	elemKL = anydiff.Scale(elemKL, c.MakeNumeric(0.5))
+	elemKL = anydiff.Sub(elemKL, logStddev)
+
+	res := anydiff.SumRows(&anydiff.Matrix{
+		Data: elemKL,
+		Rows: batchSize,
+		Cols: latentSize,
 	})
+	var rawKL float64
+	for _, x := range vectorData(res.Output()) {
+		rawKL += x
+	}
+	if t.FreeBitsGroup > 1 {
+		if latentSize%t.FreeBitsGroup != 0 {
+			panic("free-bits group size must divide latent size")
+		}
+		res = anydiff.SumCols(&anydiff.Matrix{
+			Data: res,
+			Rows: latentSize / t.FreeBitsGroup,
+			Cols: t.FreeBitsGroup,
+		})
+	}
+	if t.FreeBits != 0 {
+		// Clamp the batch average at the free bits, which
+		// is the same as clamping the sum at batchSize times
+		// the free bits.
+		minKL := c.MakeNumeric(t.FreeBits * float64(batchSize))
+		negMinKL := c.MakeNumeric(-t.FreeBits * float64(batchSize))
+		res = anydiff.AddScalar(anydiff.ClipPos(anydiff.AddScalar(res, negMinKL)), minKL)
+	}
+	return res, rawKL
+}
+
+// Parameters returns the parameters which are trained,
+// in a consistent order.
+//
+// This includes every parameter of the Encoder and the
+// Decoder, except for the parameters of Frozen parts.
+func (t *Trainer) Parameters() []*anydiff.Var {
+	frozen := map[*anydiff.Var]bool{}
+	for _, p := range parameters(t.Frozen...) {
+		frozen[p] = true
+	}
+	var res []*anydiff.Var
+	all := append(t.Encoder.Parameters(), t.Decoder.Parameters()...)
+	for _, p := range all {
+		if !frozen[p] {
+			res = append(res, p)
+		}
+	}
+	return res
 }
 
 // Gradient computes a gradient for the batch and also
 // sets t.LastCost.
 func (t *Trainer) Gradient(b anysgd.Batch) anydiff.Grad {
+	res, cost, terms := t.gradient(b)
+	t.setLast(cost, terms)
+	t.Iteration++
+	return res
+}
+
+// gradient computes a gradient for the batch without
+// modifying t.
+func (t *Trainer) gradient(b anysgd.Batch) (anydiff.Grad, anyvec.Numeric, *costTerms) {
 	res := anydiff.Grad{}
-	parts := []interface{}{t.Encoder.Block, t.Decoder.Block, t.Decoder.StateMapper}
-	for _, part := range parts {
-		if parameterizer, ok := part.(anynet.Parameterizer); ok {
-			for _, p := range parameterizer.Parameters() {
-				res[p] = p.Vector.Creator().MakeVector(p.Vector.Len())
-			}
-		}
+	for _, p := range t.Parameters() {
+		res[p] = p.Vector.Creator().MakeVector(p.Vector.Len())
 	}
-	cost := t.TotalCost(b)
-	t.LastCost = anyvec.Sum(cost.Output())
+	cost, terms := t.totalCost(b)
 	data := cost.Output().Creator().MakeNumericList([]float64{1})
 	upstream := cost.Output().Creator().MakeVectorData(data)
 	cost.Propagate(upstream, res)
-	return res
+	return res, anyvec.Sum(cost.Output()), terms
+}
+
+// setLast sets the Last fields for a batch.
+func (t *Trainer) setLast(cost anyvec.Numeric, terms *costTerms) {
+	t.LastCost = cost
+	t.LastKLWeight = t.KLAmount()
+	t.LastReconstruction = terms.Reconstruction / float64(terms.NumSamples)
+	t.LastKL = terms.KL / float64(terms.NumSamples)
+	t.LastWeightedKL = terms.WeightedKL / float64(terms.NumSamples)
+	t.LastBitsPerByte = terms.Reconstruction / (float64(terms.NumBytes) * math.Ln2)
+	t.LastActiveUnits = terms.ActiveUnits()
+	t.LastGroupKL = terms.GroupKL
+}
+
+// MeanCost computes the cost of an entire list of
+// samples, averaged over every token.
+// It is useful for measuring validation costs.
+//
+// Unlike the training cost, this is a fixed objective:
+// the reconstruction cost of decoding the posterior
+// means, plus the full KL-divergence, regardless of the
+// KL weight and FreeBits.
+// It uses no randomness, so it does not affect the
+// random draws made during training.
+//
+// The samples are processed in batches of batchSize.
+func (t *Trainer) MeanCost(s SampleList, batchSize int) (float64, error) {
+	eval := *t
+	eval.KLSchedule = nil
+	eval.KL = 1
+	eval.FreeBits = 0
+
+	var totalCost float64
+	var totalTokens int
+	for i := 0; i < len(s); i += batchSize {
+		samples := s[i:]
+		if len(samples) > batchSize {
+			samples = samples[:batchSize]
+		}
+		batch, err := eval.fetch(samples, false)
+		if err != nil {
+			return 0, err
+		}
+		batch.(*trainerBatch).UseMean = true
+		_, terms := eval.totalCost(batch)
+		totalCost += terms.Reconstruction + terms.WeightedKL
+		totalTokens += terms.NumTokens
+	}
+	return totalCost / float64(totalTokens), nil
 }
 
 func (t *Trainer) creator() anyvec.Creator {
 	return t.Decoder.Block.Parameters()[0].Vector.Creator()
 }
 
+// costTerms stores information about the components of
+// a cost, computed while the cost is being computed.
+type costTerms struct {
+	NumSamples int
+	NumTokens  int
+
+	// NumBytes counts the bytes of the samples, including
+	// a terminator for each sample.
+	NumBytes int
+
+	// Totals for the entire batch.
+	Reconstruction float64
+	KL             float64
+	WeightedKL     float64
+
+	Means   []float64
+	GroupKL []float64
+}
+
+// ActiveUnits counts the latent dimensions whose means
+// have a variance above 0.01 across the batch.
+//
+// This is the measure of activity proposed in
+// https://arxiv.org/abs/1509.00519.
+func (c *costTerms) ActiveUnits() int {
+	latentSize := len(c.Means) / c.NumSamples
+	var count int
+	for i := 0; i < latentSize; i++ {
+		var sum, sqSum float64
+		for j := 0; j < c.NumSamples; j++ {
+			x := c.Means[j*latentSize+i]
+			sum += x
+			sqSum += x * x
+		}
+		mean := sum / float64(c.NumSamples)
+		if sqSum/float64(c.NumSamples)-mean*mean > 0.01 {
+			count++
+		}
+	}
+	return count
+}
+
 type trainerBatch struct {
 	ReversedIn anyseq.Seq
 	Desired    anyseq.Seq
 	Guide      anyseq.Seq
+	NumBytes   int
+
+	// UseMean, if true, makes the decoder reconstruct the
+	// samples from the posterior means instead of from
+	// random latent vectors.
+	UseMean bool
+
+	// Noise, if non-nil, is used for the reparameterization
+	// trick instead of drawing new noise.
+	Noise anyvec.Vector
+}
+
+// teacherForcedSeqs creates the desired outputs and the
+// guide inputs for decoding each of the samples.
+func teacherForcedSeqs(c anyvec.Creator, v Vocabulary, samples [][]byte) (desired,
+	guide [][]anyvec.Vector) {
+	zero := oneHot(c, v.Size(), 0)
+	desired = make([][]anyvec.Vector, len(samples))
+	guide = make([][]anyvec.Vector, len(samples))
+	for i, data := range samples {
+		seq := []anyvec.Vector{zero}
+		for _, token := range v.Tokenize(data) {
+			seq = append(seq, oneHot(c, v.Size(), token))
+		}
+		seq = append(seq, zero)
+		desired[i] = seq[1:]
+		guide[i] = seq[:len(seq)-1]
+	}
+	return
 }
 
-func oneHot(c anyvec.Creator, b byte) anyvec.Vector {
-	data := make([]float64, 0x100)
-	data[int(b)] = 1
+func oneHot(c anyvec.Creator, size, token int) anyvec.Vector {
+	data := make([]float64, size)
+	data[token] = 1
 	return c.MakeVectorData(c.MakeNumericList(data))
 }
+
+func numericFloat(n anyvec.Numeric) float64 {
+	switch n := n.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	default:
+		panic("unsupported numeric type")
+	}
+}
+
+func vectorData(vec anyvec.Vector) []float64 {
+	switch data := vec.Data().(type) {
+	case []float64:
+		return data
+	case []float32:
+		res := make([]float64, len(data))
+		for i, x := range data {
+			res[i] = float64(x)
+		}
+		return res
+	default:
+		panic("unsupported numeric type")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/tweetenc"
+)
+
+const checkpointPrefix = "ckpt_"
+
+// A checkpointer periodically saves checkpoints to a
+// directory and deletes old ones.
+type checkpointer struct {
+	Dir string
+
+	// Interval is the number of iterations between
+	// checkpoints, or 0 to ignore iterations.
+	Interval int
+
+	// Period is the time between checkpoints, or 0 to
+	// ignore time.
+	Period time.Duration
+
+	// Keep is the number of checkpoints to keep.
+	// If 0, all checkpoints are kept.
+	Keep int
+
+	lastSave time.Time
+}
+
+// Due checks if a checkpoint should be saved after the
+// given iteration.
+func (c *checkpointer) Due(iter int) bool {
+	if c.lastSave.IsZero() {
+		c.lastSave = time.Now()
+	}
+	return (c.Interval > 0 && iter%c.Interval == 0) ||
+		(c.Period > 0 && time.Since(c.lastSave) >= c.Period)
+}
+
+// Save saves a checkpoint and deletes old ones.
+func (c *checkpointer) Save(ckpt *tweetenc.Checkpoint) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s%010d", checkpointPrefix, ckpt.Iteration)
+	if err := tweetenc.SaveAtomic(filepath.Join(c.Dir, name), ckpt); err != nil {
+		return err
+	}
+	c.lastSave = time.Now()
+
+	names, err := c.list()
+	if err != nil {
+		return err
+	}
+	if c.Keep > 0 {
+		for len(names) > c.Keep {
+			if err := os.Remove(filepath.Join(c.Dir, names[0])); err != nil {
+				return err
+			}
+			names = names[1:]
+		}
+	}
+	return nil
+}
+
+// Latest loads the most recent checkpoint.
+//
+// If there are no checkpoints, it returns nil.
+func (c *checkpointer) Latest() (*tweetenc.Checkpoint, error) {
+	names, err := c.list()
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	var res *tweetenc.Checkpoint
+	path := filepath.Join(c.Dir, names[len(names)-1])
+	if err := serializer.LoadAny(path, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// list returns the checkpoint filenames, sorted from
+// oldest to newest.
+func (c *checkpointer) list() ([]string, error) {
+	listing, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range listing {
+		name := info.Name()
+		if strings.HasPrefix(name, checkpointPrefix) && !strings.Contains(name, ".tmp") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
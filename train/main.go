@@ -1,14 +1,23 @@
+// New models are initialized from the global source,
+// which rand.Seed only seeds with this setting.
+//go:debug randseednop=0
+
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/unixpickle/anynet/anysgd"
+	"github.com/unixpickle/anyvec"
 	"github.com/unixpickle/anyvec/anyvec32"
 	"github.com/unixpickle/rip"
 	"github.com/unixpickle/serializer"
@@ -16,25 +25,102 @@ import (
 )
 
 func main() {
-	rand.Seed(time.Now().UnixNano())
-
+	var seed int64
 	var dataPath string
+	var modelPath string
 	var encPath string
 	var decPath string
+	var archPath string
 	var latent int
+	var bidir bool
+	var pooling string
+	var vocabName string
+	var vocabSize int
 	var batchSize int
+	var bucket bool
 	var stateSize int
 	var stepSize float64
+	var rateSched string
+	var decay float64
+	var decayEpochs float64
+	var minStep float64
+	var warmupIters int
+	var clipNorm float64
 	var klWeight float64
+	var klSched string
+	var klWarmup int
+	var klPeriod int
+	var klRamp float64
+	var freeBits float64
+	var freeBitsGroup int
+	var guideDropout float64
+	var freeze string
+	var workers int
+	var corruption tweetenc.Corruption
+	var metricsPath string
+	var metricsFormat string
+	var valFrac float64
+	var testFrac float64
+	var valInterval int
+	var patience int
+	var ckptDir string
+	var ckptIters int
+	var ckptMinutes float64
+	var ckptKeep int
 
 	flag.StringVar(&dataPath, "data", "", "data CSV file")
-	flag.StringVar(&encPath, "encoder", "enc_out", "encoder network path")
-	flag.StringVar(&decPath, "decoder", "dec_out", "decoder network path")
+	flag.Int64Var(&seed, "seed", 0, "random seed (0 to seed from the time)")
+	flag.StringVar(&modelPath, "model", "model_out", "model path")
+	flag.StringVar(&encPath, "encoder", "enc_out", "legacy encoder path to load from")
+	flag.StringVar(&decPath, "decoder", "dec_out", "legacy decoder path to load from")
+	flag.StringVar(&archPath, "arch", "", "JSON architecture file (overrides -latent and -state)")
 	flag.IntVar(&latent, "latent", 128, "latent vector size")
+	flag.BoolVar(&bidir, "bidir", false, "use a bidirectional encoder")
+	flag.StringVar(&pooling, "pool", "", "encoder pooling (tail, mean, max, or attention)")
+	flag.StringVar(&vocabName, "vocab", "byte",
+		"vocabulary for new models (byte, codepoint, or bpe)")
+	flag.IntVar(&vocabSize, "vocabsize", 1024,
+		"maximum codepoint or bpe vocabulary size (0 for no codepoint limit)")
 	flag.IntVar(&batchSize, "batch", 16, "SGD batch size")
+	flag.IntVar(&workers, "workers", 1, "goroutines per batch (0 for one per CPU)")
+	flag.BoolVar(&bucket, "bucket", false, "group samples of similar lengths into batches")
 	flag.IntVar(&stateSize, "state", 512, "LSTM state size")
 	flag.Float64Var(&stepSize, "step", 0.001, "SGD step size")
+	flag.StringVar(&rateSched, "ratesched", "const",
+		"step size schedule (const, step, exp, or cosine)")
+	flag.Float64Var(&decay, "decay", 0.5, "step size decay factor (step and exp)")
+	flag.Float64Var(&decayEpochs, "decayepochs", 1,
+		"epochs per decay (step) or total annealing epochs (cosine)")
+	flag.Float64Var(&minStep, "minstep", 0, "final step size (cosine)")
+	flag.IntVar(&warmupIters, "warmup", 0, "iterations of linear step size warm-up")
+	flag.Float64Var(&clipNorm, "clip", 0, "maximum gradient norm (0 for no clipping)")
 	flag.Float64Var(&klWeight, "kl", 0, "importance of KL divergence term")
+	flag.StringVar(&klSched, "klsched", "const",
+		"KL schedule (const, linear, sigmoid, or cyclical)")
+	flag.IntVar(&klWarmup, "klwarmup", 10000, "iterations to anneal the KL weight")
+	flag.IntVar(&klPeriod, "klperiod", 10000, "iterations per cyclical KL cycle")
+	flag.Float64Var(&klRamp, "klramp", 0.5, "fraction of each KL cycle spent annealing")
+	flag.Float64Var(&freeBits, "freebits", 0, "minimum KL nats per latent group")
+	flag.IntVar(&freeBitsGroup, "freebitsgroup", 1, "latent dimensions per free-bits group")
+	flag.Float64Var(&guideDropout, "guidedrop", 0, "decoder guide byte dropout rate")
+	flag.StringVar(&freeze, "freeze", "",
+		"comma-separated parts to freeze (encoder, heads, decoder, mapper)")
+	flag.Float64Var(&corruption.Substitute, "noisesub", 0, "encoder input substitution rate")
+	flag.Float64Var(&corruption.Delete, "noisedel", 0, "encoder input deletion rate")
+	flag.Float64Var(&corruption.Insert, "noiseins", 0, "encoder input insertion rate")
+	flag.Float64Var(&corruption.Swap, "noiseswap", 0, "encoder input adjacent swap rate")
+	flag.Float64Var(&corruption.CaseFlip, "noisecase", 0, "encoder input case flip rate")
+	flag.StringVar(&metricsPath, "metrics", "", "file to append per-iteration metrics to")
+	flag.StringVar(&metricsFormat, "metricsfmt", "json", "metrics format (json or csv)")
+	flag.Float64Var(&valFrac, "valfrac", 0, "fraction of samples held out for validation")
+	flag.Float64Var(&testFrac, "testfrac", 0, "fraction of samples held out for testing (see evaluate -split)")
+	flag.IntVar(&valInterval, "valinterval", 500, "iterations between validations")
+	flag.IntVar(&patience, "patience", 0,
+		"validations without improvement before stopping (0 to never stop)")
+	flag.StringVar(&ckptDir, "ckptdir", "", "directory for periodic checkpoints")
+	flag.IntVar(&ckptIters, "ckptiters", 1000, "iterations between checkpoints (0 to disable)")
+	flag.Float64Var(&ckptMinutes, "ckptmins", 0, "minutes between checkpoints (0 to disable)")
+	flag.IntVar(&ckptKeep, "ckptkeep", 3, "number of checkpoints to keep (0 for all)")
 
 	flag.Parse()
 
@@ -42,8 +128,30 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Missing -data flag. See -help for more.")
 		os.Exit(1)
 	}
+	if valFrac > 0 && valInterval <= 0 {
+		fmt.Fprintln(os.Stderr, "-valinterval must be positive.")
+		os.Exit(1)
+	}
+	if freeBits != 0 && workers != 1 {
+		fmt.Fprintln(os.Stderr, "-freebits cannot be used with multiple -workers.")
+		os.Exit(1)
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Println("Using seed", seed)
 
-	enc, dec := createOrLoad(encPath, decPath, latent, stateSize)
+	// Every source of randomness is derived from the seed,
+	// so that runs with the same seed are identical.
+	seeds := rand.New(tweetenc.NewRandSource(seed))
+	initSeed, shuffleSeed, trainSeed := seeds.Int63(), seeds.Int63(), seeds.Int63()
+
+	schedule, err := klSchedule(klSched, klWeight, klWarmup, klPeriod, klRamp)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	log.Println("Loading samples...")
 	samples, err := tweetenc.ReadSampleList(dataPath)
@@ -54,53 +162,420 @@ func main() {
 
 	log.Println("Loaded", samples.Len(), "samples")
 
+	samples, valSamples, testSamples := samples.Split(valFrac, testFrac)
+	log.Printf("Split: %d training, %d validation, %d testing", len(samples),
+		len(valSamples), len(testSamples))
+
+	var ckpts *checkpointer
+	var resumed *tweetenc.Checkpoint
+	var startIter int
+	var model *tweetenc.Model
+	if ckptDir != "" {
+		ckpts = &checkpointer{
+			Dir:      ckptDir,
+			Interval: ckptIters,
+			Period:   time.Duration(ckptMinutes * float64(time.Minute)),
+			Keep:     ckptKeep,
+		}
+		resumed, err = ckpts.Latest()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load checkpoint:", err)
+			os.Exit(1)
+		}
+	} else {
+		// Without a checkpoint directory, the training state
+		// is saved next to the model.
+		resumed, err = loadCheckpoint(modelPath + ".ckpt")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load checkpoint:", err)
+			os.Exit(1)
+		}
+	}
+	if resumed != nil {
+		log.Println("Resuming from iteration", resumed.Iteration)
+		model, startIter = resumed.Model, resumed.Iteration
+	}
+	if model == nil && anyExists(modelPath, encPath, decPath) {
+		model, err = tweetenc.LoadModelFiles(modelPath, encPath, decPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load model:", err)
+			os.Exit(1)
+		}
+	}
+	if model == nil {
+		log.Println("Creating new model...")
+		arch := tweetenc.DefaultArchitecture(latent, stateSize)
+		if archPath != "" {
+			arch, err = tweetenc.ReadArchitecture(archPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		if bidir {
+			arch.Bidirectional = true
+		}
+		if pooling != "" {
+			arch.Pooling = pooling
+			if err := arch.Validate(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		vocab, err := newVocabulary(vocabName, vocabSize, samples)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		// The constructors in anynet initialize parameters
+		// with the global source.
+		rand.Seed(initSeed)
+		model = newModel(arch, vocab)
+	}
+	model.Metadata.KL = klWeight
+	model.Metadata.Dataset = dataPath
+
+	latentSize, err := model.Check()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid model:", err)
+		os.Exit(1)
+	}
+	if freeBitsGroup < 1 || latentSize%freeBitsGroup != 0 {
+		fmt.Fprintf(os.Stderr, "-freebitsgroup must divide the latent size (%d)\n",
+			latentSize)
+		os.Exit(1)
+	}
+
 	tr := &tweetenc.Trainer{
-		Encoder: enc,
-		Decoder: dec,
-		KL:      klWeight,
-	}
-
-	var iter int
-	s := anysgd.SGD{
-		Fetcher:     tr,
-		Gradienter:  tr,
-		Transformer: &anysgd.Adam{},
-		Samples:     samples,
-		Rater:       anysgd.ConstRater(stepSize),
-		BatchSize:   batchSize,
-		StatusFunc: func(b anysgd.Batch) {
-			log.Printf("iter %d: cost=%v", iter, tr.LastCost)
-			iter++
-		},
+		Encoder:       model.Encoder,
+		Decoder:       model.Decoder,
+		KLSchedule:    schedule,
+		FreeBits:      freeBits,
+		FreeBitsGroup: freeBitsGroup,
+		GuideDropout:  guideDropout,
+		Iteration:     startIter,
+	}
+
+	if corruption != (tweetenc.Corruption{}) {
+		tr.Corruption = &corruption
+	}
+	tr.Frozen, err = frozenParts(model, freeze)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	order := &tweetenc.EpochOrder{
+		Samples:   samples,
+		BatchSize: batchSize,
+		Seed:      shuffleSeed,
+	}
+	randSource := tweetenc.NewRandSource(trainSeed)
+	tr.Rand = rand.New(randSource)
+	adam := &tweetenc.Adam{}
+	if resumed != nil {
+		randSource.State = resumed.RandState
+		order.Seed = resumed.ShuffleSeed
+		if resumed.Optimizer != nil {
+			if err := adam.SetState(tr.Parameters(), resumed.Optimizer); err != nil {
+				log.Println("Resetting optimizer:", err)
+			}
+		}
+	}
+	makeCheckpoint := func() *tweetenc.Checkpoint {
+		model.Metadata.Iteration = tr.Iteration
+		return &tweetenc.Checkpoint{
+			Model:       model,
+			Iteration:   tr.Iteration,
+			Optimizer:   adam.State(tr.Parameters()),
+			RandState:   randSource.State,
+			ShuffleSeed: order.Seed,
+		}
+	}
+
+	var metrics *metricsSink
+	if metricsPath != "" {
+		metrics, err = newMetricsSink(metricsPath, metricsFormat)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to open metrics:", err)
+			os.Exit(1)
+		}
+		defer metrics.Close()
+	}
+
+	done := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			close(done)
+		})
+	}
+	go func() {
+		<-rip.NewRIP().Chan()
+		stop()
+	}()
+
+	var valid *validator
+	if len(valSamples) > 0 {
+		valid = &validator{
+			Trainer:   tr,
+			Samples:   valSamples,
+			BatchSize: batchSize,
+			Patience:  patience,
+			Model:     model,
+			Path:      modelPath + ".best",
+			bestCost:  math.Inf(1),
+		}
+		if model.Metadata.BestValidation > 0 {
+			valid.bestCost = model.Metadata.BestValidation
+		}
+	}
+
+	rater, err := stepRater(rateSched, stepSize, decay, decayEpochs, minStep)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if bucket {
+		order.Bucket = true
+		buckets := tweetenc.BucketList(order.Epoch(0))
+		log.Printf("Padding efficiency: %.3f bucketed, %.3f unbucketed",
+			buckets.PaddingEfficiency(), samples.PaddingEfficiency(batchSize))
+	}
+	epochIters := float64(order.NumBatches())
+	if warmupIters > 0 {
+		rater = &tweetenc.WarmupRater{
+			Rater:  rater,
+			Warmup: float64(warmupIters) / epochIters,
+		}
 	}
 
+	var fetcher anysgd.Fetcher = tr
+	var baseGradienter anysgd.Gradienter = tr
+	if workers != 1 {
+		parallel := &tweetenc.ParallelTrainer{Trainer: tr, NumWorkers: workers}
+		fetcher, baseGradienter = parallel, parallel
+	}
+	gradienter := &tweetenc.ClipGradienter{Gradienter: baseGradienter, MaxNorm: clipNorm}
+
 	log.Println("Press Ctrl+C to stop.")
-	s.Run(rip.NewRIP().Chan())
+
+	// The batches come from order rather than from
+	// anysgd.SGD, whose shuffling cannot be saved and
+	// restored.
+TrainLoop:
+	for iter := startIter; ; iter++ {
+		select {
+		case <-done:
+			break TrainLoop
+		default:
+		}
+
+		batch, err := fetcher.Fetch(order.Batch(iter))
+		if err != nil {
+			log.Println("Failed to fetch batch:", err)
+			break
+		}
+		grad := adam.Transform(gradienter.Gradient(batch))
+		rate := rater.Rate(float64(iter) / epochIters)
+		for param, vec := range grad {
+			vec.Scale(vec.Creator().MakeNumeric(-rate))
+			param.Vector.Add(vec)
+		}
+
+		log.Printf("iter %d: cost=%v recon=%f kl=%f weighted_kl=%f bpb=%f "+
+			"active=%d kl_weight=%f grad_norm=%f clipped_norm=%f", iter, tr.LastCost,
+			tr.LastReconstruction, tr.LastKL, tr.LastWeightedKL, tr.LastBitsPerByte,
+			tr.LastActiveUnits, tr.LastKLWeight, gradienter.LastNorm,
+			gradienter.LastClippedNorm)
+		if metrics != nil {
+			err := metrics.Write(&metricsRecord{
+				Iteration:      iter,
+				Time:           float64(time.Now().UnixNano()) / 1e9,
+				Cost:           numericFloat(tr.LastCost),
+				Reconstruction: tr.LastReconstruction,
+				KL:             tr.LastKL,
+				WeightedKL:     tr.LastWeightedKL,
+				BitsPerByte:    tr.LastBitsPerByte,
+				ActiveUnits:    tr.LastActiveUnits,
+				StepSize:       rate,
+				KLWeight:       tr.LastKLWeight,
+				GradNorm:       gradienter.LastNorm,
+				ClippedNorm:    gradienter.LastClippedNorm,
+				BatchSize:      batchSize,
+			})
+			if err != nil {
+				log.Println("Failed to write metrics:", err)
+			}
+		}
+		if valid != nil && (iter+1)%valInterval == 0 {
+			if valid.Validate(iter + 1) {
+				log.Println("Validation cost stopped improving.")
+				stop()
+			}
+		}
+		if ckpts != nil && ckpts.Due(iter+1) {
+			if err := ckpts.Save(makeCheckpoint()); err != nil {
+				log.Println("Failed to save checkpoint:", err)
+			}
+		}
+	}
 
 	log.Println("Saving...")
 
-	if err := serializer.SaveAny(encPath, enc); err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to save encoder:", err)
+	model.Metadata.Iteration = tr.Iteration
+	if err := tweetenc.SaveAtomic(modelPath, model); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to save model:", err)
 		os.Exit(1)
 	}
-	if err := serializer.SaveAny(decPath, dec); err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to save decoder:", err)
+	if ckpts != nil {
+		err = ckpts.Save(makeCheckpoint())
+	} else {
+		err = tweetenc.SaveAtomic(modelPath+".ckpt", makeCheckpoint())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to save checkpoint:", err)
 		os.Exit(1)
 	}
 }
 
-func createOrLoad(enc, dec string, latent, state int) (*tweetenc.Encoder, *tweetenc.Decoder) {
-	encRes := &tweetenc.Encoder{}
-	if err := serializer.LoadAny(enc, &encRes); err != nil {
-		log.Println("Creating new encoder...")
-		encRes = tweetenc.NewEncoder(anyvec32.CurrentCreator(), latent, state)
+func klSchedule(name string, weight float64, warmup, period int,
+	ramp float64) (tweetenc.KLSchedule, error) {
+	switch name {
+	case "const":
+		return tweetenc.ConstKL(weight), nil
+	case "linear":
+		return &tweetenc.LinearKL{End: weight, Warmup: warmup}, nil
+	case "sigmoid":
+		if warmup <= 0 {
+			return nil, errors.New("sigmoid KL schedule requires a positive -klwarmup")
+		}
+		return &tweetenc.SigmoidKL{
+			Max:       weight,
+			Midpoint:  warmup / 2,
+			Steepness: 10 / float64(warmup),
+		}, nil
+	case "cyclical":
+		if period <= 0 {
+			return nil, errors.New("cyclical KL schedule requires a positive -klperiod")
+		}
+		return &tweetenc.CyclicalKL{
+			Max:          weight,
+			Period:       period,
+			RampFraction: ramp,
+		}, nil
+	default:
+		return nil, errors.New("unknown KL schedule: " + name)
 	}
+}
 
-	decRes := &tweetenc.Decoder{}
-	if err := serializer.LoadAny(dec, &decRes); err != nil {
-		log.Println("Creating new decoder...")
-		decRes = tweetenc.NewDecoder(anyvec32.CurrentCreator(), latent, state)
+func frozenParts(model *tweetenc.Model, names string) ([]interface{}, error) {
+	var res []interface{}
+	if names == "" {
+		return res, nil
+	}
+	for _, name := range strings.Split(names, ",") {
+		switch name {
+		case "encoder":
+			res = append(res, model.Encoder)
+		case "heads":
+			res = append(res, model.Encoder.MeanEncoder, model.Encoder.StddevEncoder)
+		case "decoder":
+			res = append(res, model.Decoder)
+		case "mapper":
+			res = append(res, model.Decoder.StateMapper)
+		default:
+			return nil, errors.New("unknown part to freeze: " + name)
+		}
 	}
+	return res, nil
+}
+
+// stepRater creates the step size schedule with the given
+// name.
+func stepRater(name string, step, decay, decayEpochs, minStep float64) (anysgd.Rater,
+	error) {
+	switch name {
+	case "const":
+		return anysgd.ConstRater(step), nil
+	case "step":
+		return &tweetenc.StepDecayRater{Base: step, Factor: decay, Interval: decayEpochs}, nil
+	case "exp":
+		return &tweetenc.ExpDecayRater{Base: step, Factor: decay}, nil
+	case "cosine":
+		return &tweetenc.CosineRater{Base: step, Min: minStep, Epochs: decayEpochs}, nil
+	default:
+		return nil, errors.New("unknown step size schedule: " + name)
+	}
+}
+
+func numericFloat(n anyvec.Numeric) float64 {
+	switch n := n.(type) {
+	case float32:
+		return float64(n)
+	case float64:
+		return n
+	default:
+		panic("unsupported numeric type")
+	}
+}
+
+func newVocabulary(name string, size int, samples tweetenc.SampleList) (tweetenc.Vocabulary,
+	error) {
+	switch name {
+	case "byte":
+		// Models without vocabularies use bytes, and they
+		// remain readable by older versions.
+		return nil, nil
+	case "codepoint":
+		return tweetenc.NewCodepointVocabulary(samples, size), nil
+	case "bpe":
+		// The terminator and the 256 bytes are not merges.
+		if size <= 0x101 {
+			return nil, errors.New("bpe vocabulary requires a -vocabsize above 257")
+		}
+		numMerges := size - 0x101
+		log.Println("Learning BPE merges...")
+		vocab := tweetenc.NewBPEVocabulary(samples, numMerges)
+		log.Println("Learned", len(vocab.Merges), "merges")
+		return vocab, nil
+	default:
+		return nil, errors.New("unknown vocabulary: " + name)
+	}
+}
 
-	return encRes, decRes
+func newModel(arch *tweetenc.Architecture, vocab tweetenc.Vocabulary) *tweetenc.Model {
+	c := anyvec32.CurrentCreator()
+	return &tweetenc.Model{
+		Encoder: tweetenc.NewEncoderArch(c, arch, vocab),
+		Decoder: tweetenc.NewDecoderArch(c, arch, vocab),
+		Metadata: tweetenc.ModelMetadata{
+			LatentSize:   arch.LatentSize,
+			StateSize:    arch.StateSize(),
+			Architecture: arch,
+		},
+	}
+}
+
+// loadCheckpoint loads a checkpoint file, or returns nil
+// if the file does not exist.
+func loadCheckpoint(path string) (*tweetenc.Checkpoint, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	var res *tweetenc.Checkpoint
+	if err := serializer.LoadAny(path, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// anyExists checks if any of the files exist.
+func anyExists(paths ...string) bool {
+	for _, path := range paths {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+)
+
+// A metricsRecord stores the statistics for one training
+// iteration.
+type metricsRecord struct {
+	Iteration      int     `json:"iteration"`
+	Time           float64 `json:"time"`
+	Cost           float64 `json:"cost"`
+	Reconstruction float64 `json:"reconstruction"`
+	KL             float64 `json:"kl"`
+	WeightedKL     float64 `json:"weighted_kl"`
+	BitsPerByte    float64 `json:"bits_per_byte"`
+	ActiveUnits    int     `json:"active_units"`
+	StepSize       float64 `json:"step_size"`
+	KLWeight       float64 `json:"kl_weight"`
+	GradNorm       float64 `json:"grad_norm"`
+	ClippedNorm    float64 `json:"clipped_grad_norm"`
+	BatchSize      int     `json:"batch_size"`
+}
+
+var metricsColumns = []string{"iteration", "time", "cost", "reconstruction", "kl",
+	"weighted_kl", "bits_per_byte", "active_units", "step_size", "kl_weight",
+	"grad_norm", "clipped_grad_norm", "batch_size"}
+
+func (m *metricsRecord) csvRow() []string {
+	f := func(x float64) string {
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	return []string{strconv.Itoa(m.Iteration), f(m.Time), f(m.Cost),
+		f(m.Reconstruction), f(m.KL), f(m.WeightedKL), f(m.BitsPerByte),
+		strconv.Itoa(m.ActiveUnits), f(m.StepSize), f(m.KLWeight), f(m.GradNorm),
+		f(m.ClippedNorm), strconv.Itoa(m.BatchSize)}
+}
+
+// A metricsSink writes metrics records to a file.
+//
+// Records are appended to the file, so a resumed run
+// continues the log of the previous run.
+type metricsSink struct {
+	file      *os.File
+	csvWriter *csv.Writer
+}
+
+// newMetricsSink opens a metrics file for appending.
+//
+// The format may be "json" for newline-delimited JSON
+// objects or "csv" for CSV rows.
+// A CSV header is only written if the file is empty.
+func newMetricsSink(path, format string) (*metricsSink, error) {
+	if format != "json" && format != "csv" {
+		return nil, errors.New("unknown metrics format: " + format)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	res := &metricsSink{file: f}
+	if format == "csv" {
+		res.csvWriter = csv.NewWriter(f)
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if info.Size() == 0 {
+			if err := res.writeCSV(metricsColumns); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// Write appends a record to the file.
+func (m *metricsSink) Write(r *metricsRecord) error {
+	if m.csvWriter != nil {
+		return m.writeCSV(r.csvRow())
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = m.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the underlying file.
+func (m *metricsSink) Close() error {
+	return m.file.Close()
+}
+
+func (m *metricsSink) writeCSV(row []string) error {
+	if err := m.csvWriter.Write(row); err != nil {
+		return err
+	}
+	m.csvWriter.Flush()
+	return m.csvWriter.Error()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+
+	"github.com/unixpickle/tweetenc"
+)
+
+// A validator periodically measures the validation cost,
+// keeps track of the best model, and decides when to stop
+// training.
+type validator struct {
+	Trainer   *tweetenc.Trainer
+	Samples   tweetenc.SampleList
+	BatchSize int
+
+	// Patience is the number of validations without any
+	// improvement before training should stop.
+	// If 0, training never stops early.
+	Patience int
+
+	// Model is saved to Path whenever it is the best model
+	// so far.
+	Model *tweetenc.Model
+	Path  string
+
+	bestCost float64
+	numBad   int
+}
+
+// Validate measures the validation cost, saves the model
+// if it is the best so far, and reports
+// whether or not training should stop.
+func (v *validator) Validate(iter int) bool {
+	cost, err := v.Trainer.MeanCost(v.Samples, v.BatchSize)
+	if err != nil {
+		log.Println("Failed to compute validation cost:", err)
+		return false
+	}
+	if cost < v.bestCost {
+		log.Printf("iter %d: validation=%f (new best)", iter, cost)
+		v.bestCost = cost
+		v.numBad = 0
+		v.Model.Metadata.Iteration = iter
+		v.Model.Metadata.BestValidation = cost
+		if err := tweetenc.SaveAtomic(v.Path, v.Model); err != nil {
+			log.Println("Failed to save best model:", err)
+		}
+		return false
+	}
+	v.numBad++
+	log.Printf("iter %d: validation=%f (best=%f, %d without improvement)", iter, cost,
+		v.bestCost, v.numBad)
+	return v.Patience > 0 && v.numBad >= v.Patience
+}
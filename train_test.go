@@ -0,0 +1,156 @@
+// TestTrainerDeterministic seeds the global source,
+// which rand.Seed only does with this setting.
+//go:debug randseednop=0
+
+package tweetenc
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anyvec/anyvec64"
+)
+
+func TestTrainerGradient(t *testing.T) {
+	trainer := testTrainer()
+	trainer.KL = 1
+	batch, err := trainer.Fetch(testSamples())
+	if err != nil {
+		t.Fatal(err)
+	}
+	grad := trainer.Gradient(batch)
+
+	params := trainer.Parameters()
+	heads := parameters(trainer.Encoder.MeanEncoder, trainer.Encoder.StddevEncoder)
+	if len(heads) == 0 {
+		t.Fatal("encoder heads have no parameters")
+	}
+	for i, param := range heads {
+		if !containsParams(params, []*anydiff.Var{param}) {
+			t.Errorf("head parameter %d: missing from parameters", i)
+		}
+	}
+	for i, param := range params {
+		vec, ok := grad[param]
+		if !ok {
+			t.Errorf("parameter %d: missing from gradient", i)
+			continue
+		}
+		var nonZero bool
+		for _, x := range vectorData(vec) {
+			if x != 0 {
+				nonZero = true
+				break
+			}
+		}
+		if !nonZero {
+			t.Errorf("parameter %d: gradient is zero", i)
+		}
+	}
+}
+
+func TestTrainerFrozen(t *testing.T) {
+	trainer := testTrainer()
+	trainer.Frozen = []interface{}{trainer.Encoder.MeanEncoder, trainer.Decoder.StateMapper}
+	batch, err := trainer.Fetch(testSamples())
+	if err != nil {
+		t.Fatal(err)
+	}
+	grad := trainer.Gradient(batch)
+
+	frozen := parameters(trainer.Frozen...)
+	if containsParams(trainer.Parameters(), frozen) {
+		t.Error("frozen parameters should not be trained")
+	}
+	for i, param := range frozen {
+		if _, ok := grad[param]; ok {
+			t.Errorf("frozen parameter %d: should not be in gradient", i)
+		}
+	}
+	for i, param := range trainer.Parameters() {
+		if _, ok := grad[param]; !ok {
+			t.Errorf("parameter %d: missing from gradient", i)
+		}
+	}
+}
+
+func TestTrainerDeterministic(t *testing.T) {
+	run := func() []*anydiff.Var {
+		rand.Seed(42)
+		trainer := testTrainer()
+		trainer.KL = 0.5
+		trainer.GuideDropout = 0.1
+		trainer.Corruption = &Corruption{Substitute: 0.1, Delete: 0.1}
+		trainer.Rand = rand.New(NewRandSource(1337))
+		order := &EpochOrder{Samples: testSamples(), BatchSize: 2, Seed: 7}
+		adam := &Adam{}
+		for i := 0; i < 2*order.NumBatches(); i++ {
+			batch, err := trainer.Fetch(order.Batch(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			grad := adam.Transform(trainer.Gradient(batch))
+			for param, vec := range grad {
+				vec.Scale(vec.Creator().MakeNumeric(-0.01))
+				param.Vector.Add(vec)
+			}
+		}
+		return trainer.Parameters()
+	}
+
+	params1 := run()
+	params2 := run()
+	if len(params1) != len(params2) {
+		t.Fatalf("expected %d parameters but got %d", len(params1), len(params2))
+	}
+	for i, param := range params1 {
+		expected := vectorData(param.Vector)
+		actual := vectorData(params2[i].Vector)
+		for j, x := range expected {
+			if actual[j] != x {
+				t.Errorf("parameter %d: entry %d should be %f but got %f", i, j, x,
+					actual[j])
+				break
+			}
+		}
+	}
+}
+
+// containsParams checks if any of the needles are in the
+// haystack.
+func containsParams(haystack, needles []*anydiff.Var) bool {
+	set := map[*anydiff.Var]bool{}
+	for _, p := range haystack {
+		set[p] = true
+	}
+	for _, p := range needles {
+		if set[p] {
+			return true
+		}
+	}
+	return false
+}
+
+func testTrainer() *Trainer {
+	c := anyvec64.DefaultCreator{}
+	arch := &Architecture{
+		LatentSize:    4,
+		EncoderLayers: []*LayerSpec{{Cell: "lstm", StateSize: 6}},
+		DecoderLayers: []*LayerSpec{{Cell: "lstm", StateSize: 6}},
+	}
+	return &Trainer{
+		Encoder: NewEncoderArch(c, arch, nil),
+		Decoder: NewDecoderArch(c, arch, nil),
+	}
+}
+
+func testSamples() SampleList {
+	return SampleList{
+		[]byte("hello world"),
+		[]byte("hi"),
+		[]byte("testing, testing"),
+		[]byte("a"),
+		[]byte("the quick brown fox"),
+	}
+}
@@ -0,0 +1,204 @@
+package tweetenc
+
+import (
+	"errors"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/unixpickle/serializer"
+)
+
+func init() {
+	var b ByteVocabulary
+	serializer.RegisterTypedDeserializer(b.SerializerType(), DeserializeByteVocabulary)
+	var c CodepointVocabulary
+	serializer.RegisterTypedDeserializer(c.SerializerType(),
+		DeserializeCodepointVocabulary)
+}
+
+// A Vocabulary converts between strings of bytes and
+// sequences of tokens, which are what encoders and
+// decoders actually process.
+//
+// Token 0 is the terminator, which starts and ends every
+// sequence that a Decoder produces.
+// Tokenize never produces it, and Detokenize should not
+// be given it.
+type Vocabulary interface {
+	serializer.Serializer
+
+	// Size returns the number of tokens, including the
+	// terminator.
+	Size() int
+
+	// Tokenize converts a string into tokens.
+	Tokenize(data []byte) []int
+
+	// Detokenize converts tokens back into a string.
+	Detokenize(tokens []int) []byte
+}
+
+// ByteVocabulary is a Vocabulary where every byte is a
+// token.
+//
+// The null byte doubles as the terminator.
+type ByteVocabulary struct{}
+
+// DeserializeByteVocabulary deserializes a
+// ByteVocabulary.
+func DeserializeByteVocabulary(d []byte) (ByteVocabulary, error) {
+	return ByteVocabulary{}, nil
+}
+
+// Size returns 256.
+func (b ByteVocabulary) Size() int {
+	return 0x100
+}
+
+// Tokenize converts each byte to a token.
+func (b ByteVocabulary) Tokenize(data []byte) []int {
+	res := make([]int, len(data))
+	for i, x := range data {
+		res[i] = int(x)
+	}
+	return res
+}
+
+// Detokenize converts each token to a byte.
+func (b ByteVocabulary) Detokenize(tokens []int) []byte {
+	res := make([]byte, len(tokens))
+	for i, x := range tokens {
+		res[i] = byte(x)
+	}
+	return res
+}
+
+// SerializerType returns the unique ID used to serialize
+// a ByteVocabulary with the serializer package.
+func (b ByteVocabulary) SerializerType() string {
+	return "github.com/unixpickle/tweetenc.ByteVocabulary"
+}
+
+// Serialize serializes the ByteVocabulary.
+func (b ByteVocabulary) Serialize() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// A CodepointVocabulary is a Vocabulary where every
+// Unicode codepoint is a token.
+//
+// Decoded strings are always valid UTF-8, since the
+// decoder cannot stop in the middle of a character.
+type CodepointVocabulary struct {
+	// Runes contains the known codepoints.
+	// Token 0 is the terminator, token 1 stands for any
+	// unknown codepoint or invalid byte, and token i+2 is
+	// Runes[i].
+	Runes []rune
+
+	tokens map[rune]int
+}
+
+// NewCodepointVocabulary creates a CodepointVocabulary
+// with the most frequent codepoints in the samples.
+//
+// If maxSize is non-zero, it limits the size of the
+// vocabulary, including the terminator and unknown
+// tokens.
+func NewCodepointVocabulary(s SampleList, maxSize int) *CodepointVocabulary {
+	counts := map[rune]int{}
+	for _, sample := range s {
+		for _, r := range string(sample) {
+			if r != utf8.RuneError {
+				counts[r]++
+			}
+		}
+	}
+	var runes []rune
+	for r := range counts {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool {
+		if counts[runes[i]] != counts[runes[j]] {
+			return counts[runes[i]] > counts[runes[j]]
+		}
+		return runes[i] < runes[j]
+	})
+	if maxSize > 0 && len(runes) > maxSize-2 {
+		runes = runes[:maxSize-2]
+	}
+	res := &CodepointVocabulary{Runes: runes}
+	res.buildTokens()
+	return res
+}
+
+// DeserializeCodepointVocabulary deserializes a
+// CodepointVocabulary.
+func DeserializeCodepointVocabulary(d []byte) (*CodepointVocabulary, error) {
+	var data []byte
+	if err := serializer.DeserializeAny(d, &data); err != nil {
+		return nil, errors.New("deserialize CodepointVocabulary: " + err.Error())
+	}
+	if !utf8.Valid(data) {
+		return nil, errors.New("deserialize CodepointVocabulary: invalid UTF-8")
+	}
+	res := &CodepointVocabulary{Runes: []rune(string(data))}
+	res.buildTokens()
+	return res, nil
+}
+
+// Size returns the number of tokens.
+func (c *CodepointVocabulary) Size() int {
+	return len(c.Runes) + 2
+}
+
+// Tokenize converts each codepoint to a token.
+func (c *CodepointVocabulary) Tokenize(data []byte) []int {
+	if c.tokens == nil {
+		c.buildTokens()
+	}
+	var res []int
+	for _, r := range string(data) {
+		if token, ok := c.tokens[r]; ok {
+			res = append(res, token)
+		} else {
+			res = append(res, 1)
+		}
+	}
+	return res
+}
+
+// Detokenize converts tokens back to UTF-8.
+//
+// Unknown tokens become the Unicode replacement
+// character.
+func (c *CodepointVocabulary) Detokenize(tokens []int) []byte {
+	res := []byte{}
+	for _, token := range tokens {
+		r := utf8.RuneError
+		if token >= 2 {
+			r = c.Runes[token-2]
+		}
+		var buf [utf8.UTFMax]byte
+		res = append(res, buf[:utf8.EncodeRune(buf[:], r)]...)
+	}
+	return res
+}
+
+func (c *CodepointVocabulary) buildTokens() {
+	c.tokens = map[rune]int{}
+	for i, r := range c.Runes {
+		c.tokens[r] = i + 2
+	}
+}
+
+// SerializerType returns the unique ID used to serialize
+// a CodepointVocabulary with the serializer package.
+func (c *CodepointVocabulary) SerializerType() string {
+	return "github.com/unixpickle/tweetenc.CodepointVocabulary"
+}
+
+// Serialize serializes the CodepointVocabulary.
+func (c *CodepointVocabulary) Serialize() ([]byte, error) {
+	return serializer.SerializeAny([]byte(string(c.Runes)))
+}